@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// maxStageParams keeps the number of parameters of each staging INSERT
+// under SQLite's default limit of 999.
+const maxStageParams = 800
+
+// pairAttributes selects the column of each optional pair attribute (see
+// sources.PairAttributes) loaded with a mapping.
+type pairAttributes map[string]string
+
+// names returns the selected attributes in a consistent order.
+func (a pairAttributes) names() []string {
+	var res []string
+	for _, attr := range sources.PairAttributes {
+		if a[attr] != "" {
+			res = append(res, attr)
+		}
+	}
+	return res
+}
+
+// columns returns the column selectors of the selected attributes.
+func (a pairAttributes) columns() []string {
+	var res []string
+	for _, attr := range a.names() {
+		res = append(res, a[attr])
+	}
+	return res
+}
+
+// validate checks that only supported attributes are selected.
+func (a pairAttributes) validate() error {
+	for attr := range a {
+		if _, ok := pairColumnTypes[attr]; !ok {
+			return fmt.Errorf("unknown pair attribute %q (supported: %s)", attr,
+				strings.Join(sources.PairAttributes, ", "))
+		}
+	}
+	return nil
+}
+
+// pairColumnTypes are the SQL types of the pair attribute columns.
+var pairColumnTypes = map[string]string{
+	sources.AttrRelationship: "varchar",
+	sources.AttrEvidence:     "varchar",
+	sources.AttrTaxon:        "varchar",
+	sources.AttrRank:         "integer",
+}
+
+// createMappingTable creates a mapping table with the pair attribute
+// columns, or adds the columns to an existing table.
+func createMappingTable(tx dbtx, table string, attrs []string) error {
+	cols := ""
+	for _, attr := range attrs {
+		cols += fmt.Sprintf("\n\t\t\t%s %s,", attr, pairColumnTypes[attr])
+	}
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+			left_id varchar,
+			right_id varchar,` + cols + `
+			primary key(left_id,right_id)
+		);`)
+	if err != nil {
+		return err
+	}
+	existing, err := tableAttributes(tx, "main", table)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		if existing[attr] {
+			continue
+		}
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, attr, pairColumnTypes[attr]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseIndex returns the name of the index of a mapping table by right
+// identifier.
+func reverseIndex(leftID, rightID int64) string {
+	return fmt.Sprintf("mapping_%d_to_%d_idx", rightID, leftID)
+}
+
+// createReverseIndex indexes a mapping table in the schema by right
+// identifier.
+func createReverseIndex(tx dbtx, schema string, leftID, rightID int64) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s.%s ON mapping_%d_to_%d (right_id,left_id);`,
+		schema, reverseIndex(leftID, rightID), leftID, rightID))
+	return err
+}
+
+// tableAttributes returns the pair attribute columns of a mapping table.
+func tableAttributes(db dbtx, schema, table string) (map[string]bool, error) {
+	res := make(map[string]bool)
+	for _, attr := range sources.PairAttributes {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?,?) WHERE name=?;`,
+			table, schema, attr).Scan(&n)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			res[attr] = true
+		}
+	}
+	return res, nil
+}
+
+// pairLoader bulk loads pairs of identifiers into a mapping table. Pairs
+// are staged into a temporary table without any index, then sorted,
+// deduplicated and appended to the mapping table in one statement, which
+// is much faster than checking for conflicts one row at a time.
+type pairLoader struct {
+	tx    *sql.Tx
+	table string
+	attrs []string
+	stmt  *sql.Stmt
+	batch []interface{}
+
+	staged int
+	start  time.Time
+}
+
+// newPairLoader prepares to load pairs, with values of the pair attributes,
+// into a mapping table.
+func newPairLoader(tx *sql.Tx, table string, attrs []string) (*pairLoader, error) {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS temp.pair_stage;`)
+	if err != nil {
+		return nil, err
+	}
+	cols := ""
+	for _, attr := range attrs {
+		cols += ", " + attr + " " + pairColumnTypes[attr]
+	}
+	_, err = tx.Exec(`CREATE TEMP TABLE pair_stage (left_id varchar, right_id varchar` + cols + `);`)
+	if err != nil {
+		return nil, err
+	}
+	p := &pairLoader{
+		tx:    tx,
+		table: table,
+		attrs: attrs,
+		start: time.Now(),
+	}
+	rows := maxStageParams / (2 + len(attrs))
+	p.batch = make([]interface{}, 0, rows*(2+len(attrs)))
+	if p.stmt, err = tx.Prepare(p.stageQuery(rows)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// stageQuery returns an INSERT of n rows into the staging table.
+func (p *pairLoader) stageQuery(n int) string {
+	cols := strings.Join(append([]string{"left_id", "right_id"}, p.attrs...), ",")
+	row := "(" + strings.TrimSuffix(strings.Repeat("?,", 2+len(p.attrs)), ",") + "),"
+	return `INSERT INTO temp.pair_stage (` + cols + `) VALUES ` +
+		strings.TrimSuffix(strings.Repeat(row, n), ",") + `;`
+}
+
+// Add stages a pair of identifiers, with a value (or nil) for each of the
+// pair attributes.
+func (p *pairLoader) Add(left, right string, attrVals ...interface{}) error {
+	p.batch = append(p.batch, left, right)
+	p.batch = append(p.batch, attrVals...)
+	p.staged++
+	if len(p.batch) < cap(p.batch) {
+		return nil
+	}
+	_, err := p.stmt.Exec(p.batch...)
+	p.batch = p.batch[:0]
+	return err
+}
+
+// Load appends the staged pairs which aren't already in the mapping table,
+// and returns the number of pairs added.
+func (p *pairLoader) Load() (int64, error) {
+	p.stmt.Close()
+	if len(p.batch) > 0 {
+		if _, err := p.tx.Exec(p.stageQuery(len(p.batch)/(2+len(p.attrs))), p.batch...); err != nil {
+			return 0, err
+		}
+		p.batch = p.batch[:0]
+	}
+	staging := time.Since(p.start)
+
+	// EXCEPT removes duplicates, and the sorted rows are appended to the
+	// primary key in order.
+	q := fmt.Sprintf(`INSERT INTO %[1]s (left_id,right_id)
+		SELECT left_id,right_id FROM temp.pair_stage EXCEPT SELECT left_id,right_id FROM %[1]s
+		ORDER BY 1,2;`, p.table)
+	if len(p.attrs) > 0 {
+		// a pair listed more than once keeps the lowest value of each attribute
+		cols, mins := "", ""
+		for _, attr := range p.attrs {
+			cols += "," + attr
+			mins += ",MIN(" + attr + ")"
+		}
+		q = fmt.Sprintf(`INSERT INTO %[1]s (left_id,right_id%[2]s)
+			SELECT * FROM (SELECT left_id,right_id%[3]s FROM temp.pair_stage GROUP BY left_id,right_id) s
+			WHERE NOT EXISTS (SELECT 1 FROM %[1]s t WHERE t.left_id=s.left_id AND t.right_id=s.right_id)
+			ORDER BY 1,2;`, p.table, cols, mins)
+	}
+	res, err := p.tx.Exec(q)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = p.tx.Exec(`DROP TABLE temp.pair_stage;`); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(p.start)
+	log.Printf("%s :: %d rows staged in %s, %d pairs loaded in %s (%.0f rows/s)", p.table, p.staged,
+		staging.Round(time.Millisecond), n, elapsed.Round(time.Millisecond),
+		float64(p.staged)/elapsed.Seconds())
+	return n, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err = initDB(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// loadPairs stages the pairs, each with an optional rank, and loads them.
+func loadPairs(t *testing.T, db *sql.DB, attrs []string, pairs [][]interface{}) int64 {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err = createMappingTable(tx, "mapping_1_to_2", attrs); err != nil {
+		t.Fatal(err)
+	}
+	loader, err := newPairLoader(tx, "mapping_1_to_2", attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pairs {
+		if err = loader.Add(p[0].(string), p[1].(string), p[2:]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPairLoaderDeduplicates(t *testing.T) {
+	db := openTestDB(t)
+
+	// more pairs than fit in one staging INSERT, each listed twice
+	var pairs [][]interface{}
+	for i := 0; i < 1000; i++ {
+		p := []interface{}{fmt.Sprintf("L%d", i%500), fmt.Sprintf("R%d", i)}
+		pairs = append(pairs, p, p)
+	}
+	if n := loadPairs(t, db, nil, pairs); n != 1000 {
+		t.Errorf("first load added %d pairs, want 1000", n)
+	}
+
+	// pairs already in the table aren't added again
+	pairs = [][]interface{}{{"L1", "R1"}, {"L1", "R1"}, {"L1", "R2000"}}
+	if n := loadPairs(t, db, nil, pairs); n != 1 {
+		t.Errorf("second load added %d pairs, want 1", n)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM mapping_1_to_2;`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1001 {
+		t.Errorf("table has %d pairs, want 1001", count)
+	}
+}
+
+func TestPairLoaderAttributes(t *testing.T) {
+	db := openTestDB(t)
+	attrs := []string{"taxon", "rank"}
+	pairs := [][]interface{}{
+		{"L1", "R1", "9606", 2},
+		{"L1", "R1", "9606", 1},
+		{"L1", "R2", nil, nil},
+		{"L2", "R1", "10090", 3},
+	}
+	if n := loadPairs(t, db, attrs, pairs); n != 3 {
+		t.Errorf("load added %d pairs, want 3", n)
+	}
+
+	rows, err := db.Query(`SELECT left_id,right_id,taxon,rank FROM mapping_1_to_2 ORDER BY 1,2;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var l, r string
+		var taxon sql.NullString
+		var rank sql.NullInt64
+		if err = rows.Scan(&l, &r, &taxon, &rank); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %v %v", l, r, taxon, rank))
+	}
+	// a pair listed twice keeps the lowest rank, and blanks are NULL
+	want := []string{
+		"L1 R1 {9606 true} {1 true}",
+		"L1 R2 { false} {0 false}",
+		"L2 R1 {10090 true} {3 true}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pairs = %q, want %q", got, want)
+	}
+}
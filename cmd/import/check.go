@@ -0,0 +1,341 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// checkIssue is a single problem found in the source database.
+type checkIssue struct {
+	// Level is "error" or "warning".
+	Level string `json:"level"`
+
+	// Check names the test which failed, e.g. "element_count".
+	Check string `json:"check"`
+
+	// Source names the source, or the left<>right sources of a mapping.
+	Source string `json:"source,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// checkReport is written as JSON by the check command.
+type checkReport struct {
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Issues   []*checkIssue `json:"issues"`
+}
+
+func (r *checkReport) errorf(check, source, format string, args ...interface{}) {
+	r.Errors++
+	r.Issues = append(r.Issues, &checkIssue{"error", check, source, fmt.Sprintf(format, args...)})
+}
+
+func (r *checkReport) warnf(check, source, format string, args ...interface{}) {
+	r.Warnings++
+	r.Issues = append(r.Issues, &checkIssue{"warning", check, source, fmt.Sprintf(format, args...)})
+}
+
+// risLine matches a tagged line of a refman citation.
+var risLine = regexp.MustCompile(`^[A-Z][A-Z0-9]  - `)
+
+// checkedSource holds the metadata and indexes of a source being checked.
+type checkedSource struct {
+	name string
+	bf   []*sources.BloomFilter
+}
+
+// checkDatabase audits the metadata, indexes and mappings of the source
+// database, sampling up to sampleSize pairs of each mapping against the
+// indexes of its sources. The report is written to stdout as JSON, and an
+// error is returned if any errors were found.
+func checkDatabase(db *sql.DB, sampleSize int) error {
+	report := &checkReport{Issues: []*checkIssue{}}
+	srcs, err := checkSources(db, report)
+	if err != nil {
+		return err
+	}
+	if err = checkIndexes(db, srcs, report); err != nil {
+		return err
+	}
+	if err = checkMappings(db, srcs, sampleSize, report); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(report); err != nil {
+		return err
+	}
+	if report.Errors > 0 {
+		return fmt.Errorf("%d errors found", report.Errors)
+	}
+	return nil
+}
+
+func checkSources(db *sql.DB, report *checkReport) (map[int64]*checkedSource, error) {
+	rows, err := db.Query("SELECT source_id,name,description,ident_type,url,id_url,citedata FROM sources;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	srcs := make(map[int64]*checkedSource)
+	for rows.Next() {
+		var id int64
+		var name string
+		var desc, identType, url, idURL, citedata sql.NullString
+		err = rows.Scan(&id, &name, &desc, &identType, &url, &idURL, &citedata)
+		if err != nil {
+			return nil, err
+		}
+		srcs[id] = &checkedSource{name: name}
+
+		// sources.Open can't load NULL metadata
+		for _, f := range []struct {
+			column string
+			value  sql.NullString
+		}{
+			{"description", desc}, {"ident_type", identType}, {"url", url}, {"id_url", idURL}, {"citedata", citedata},
+		} {
+			if !f.value.Valid {
+				report.errorf("metadata", name, "%s is not set", f.column)
+			}
+		}
+		switch {
+		case desc.Valid && strings.TrimSpace(desc.String) == "":
+			report.warnf("metadata", name, "description is empty")
+		case url.Valid && strings.TrimSpace(url.String) == "":
+			report.warnf("metadata", name, "url is empty")
+		}
+		if idURL.Valid {
+			switch {
+			case idURL.String == "":
+				report.warnf("id_url", name, "id_url is empty, identifiers link to the source url")
+			case strings.Count(idURL.String, "%s") != 1:
+				report.errorf("id_url", name, "id_url %q must have one '%%s' placeholder", idURL.String)
+			}
+		}
+		if citedata.Valid {
+			checkCitation(name, citedata.String, report)
+		}
+	}
+	return srcs, rows.Err()
+}
+
+// checkCitation checks that the refman (RIS) citation can be formatted by
+// sources.Source.Cite.
+func checkCitation(name, citedata string, report *checkReport) {
+	if strings.TrimSpace(citedata) == "" {
+		report.warnf("citation", name, "no citation")
+		return
+	}
+	if !strings.Contains(citedata, "\r\n") {
+		report.errorf("citation", name, "citation lines must end in CRLF")
+		return
+	}
+	tags := make(map[string]bool)
+	var first, last string
+	for i, line := range strings.Split(citedata, "\r\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !risLine.MatchString(line) && line != "ER  -" {
+			report.errorf("citation", name, "line %d is not a tagged RIS line: %q", i+1, line)
+			return
+		}
+		tag := line[:2]
+		if first == "" {
+			first = tag
+		}
+		last = tag
+		tags[tag] = true
+	}
+	if first != "TY" || last != "ER" {
+		report.errorf("citation", name, "citation must start with TY and end with ER")
+	}
+	for _, tag := range []string{"AU", "TI", "PY"} {
+		if !tags[tag] {
+			report.errorf("citation", name, "citation has no %s tag", tag)
+		}
+	}
+	if !tags["T2"] {
+		report.warnf("citation", name, "citation has no journal (T2 tag)")
+	}
+}
+
+func checkIndexes(db *sql.DB, srcs map[int64]*checkedSource, report *checkReport) error {
+	rows, err := db.Query("SELECT source_id,subset,last_update,element_count,bloom FROM source_indexes;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var subset string
+		var updated sql.NullString
+		var count sql.NullInt64
+		var data []byte
+		if err = rows.Scan(&id, &subset, &updated, &count, &data); err != nil {
+			return err
+		}
+		src, ok := srcs[id]
+		if !ok {
+			report.errorf("index", fmt.Sprint(id), "index [%s] of a source which doesn't exist", subset)
+			continue
+		}
+		if !updated.Valid {
+			report.warnf("last_update", src.name, "index [%s] has no last_update", subset)
+		}
+		bf := &sources.BloomFilter{}
+		if err = bf.Unpack(data); err != nil {
+			report.errorf("index", src.name, "index [%s] can't be unpacked: %v", subset, err)
+			continue
+		}
+		if !count.Valid || uint64(count.Int64) != bf.Count() {
+			report.warnf("element_count", src.name, "index [%s] element_count is %d, but %d were indexed",
+				subset, count.Int64, bf.Count())
+		}
+		src.bf = append(src.bf, bf)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	var names []string
+	for _, src := range srcs {
+		if len(src.bf) == 0 {
+			names = append(names, src.name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.warnf("index", name, "source has no index, so it is never detected")
+	}
+	return nil
+}
+
+func checkMappings(db *sql.DB, srcs map[int64]*checkedSource, sampleSize int, report *checkReport) error {
+	type mapping struct {
+		leftID, rightID int64
+		lr, rl          string
+		count           sql.NullInt64
+	}
+	rows, err := db.Query(`SELECT left_source_id,right_source_id,map_query_lr,map_query_rl,element_count
+		FROM source_mappings;`)
+	if err != nil {
+		return err
+	}
+	var maps []*mapping
+	for rows.Next() {
+		m := &mapping{}
+		if err = rows.Scan(&m.leftID, &m.rightID, &m.lr, &m.rl, &m.count); err != nil {
+			rows.Close()
+			return err
+		}
+		maps = append(maps, m)
+	}
+	rows.Close()
+
+	for _, m := range maps {
+		left, lok := srcs[m.leftID]
+		right, rok := srcs[m.rightID]
+		if !lok || !rok {
+			report.errorf("mapping", fmt.Sprintf("%d<>%d", m.leftID, m.rightID), "mapping of a source which doesn't exist")
+			continue
+		}
+		name := left.name + "<>" + right.name
+		if m.leftID >= m.rightID {
+			report.errorf("mapping", name, "left_source_id must be less than right_source_id")
+		}
+		for _, s := range []*checkedSource{left, right} {
+			if len(s.bf) == 0 {
+				report.errorf("mapping", name, "%s has no index", s.name)
+			}
+		}
+
+		table := fmt.Sprintf("mapping_%d_to_%d", m.leftID, m.rightID)
+		if !strings.Contains(m.lr, table) || !strings.Contains(m.rl, table) {
+			report.errorf("mapping", name, "map queries don't use %s", table)
+		}
+		var n int64
+		err = db.QueryRow("SELECT COUNT(*) FROM " + table + ";").Scan(&n)
+		if err != nil {
+			report.errorf("mapping", name, "%s can't be read: %v", table, err)
+			continue
+		}
+		if !m.count.Valid || m.count.Int64 != n {
+			report.errorf("element_count", name, "element_count is %d, but %s has %d pairs", m.count.Int64, table, n)
+		}
+		if n == 0 {
+			report.warnf("mapping", name, "%s is empty", table)
+			continue
+		}
+		if err = checkMappingSample(db, table, name, left, right, sampleSize, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMappingSample tests a random sample of the mapped identifiers against
+// the indexes of each source. Bloom filters have no false negatives, so any
+// identifier which isn't detected is missing from the index.
+func checkMappingSample(db *sql.DB, table, name string, left, right *checkedSource, sampleSize int, report *checkReport) error {
+	rows, err := db.Query("SELECT left_id,right_id FROM "+table+" ORDER BY RANDOM() LIMIT ?;", sampleSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sampled := 0
+	missing := make(map[*checkedSource][]string)
+	for rows.Next() {
+		var ids [2]string
+		if err = rows.Scan(&ids[0], &ids[1]); err != nil {
+			return err
+		}
+		sampled++
+		for i, src := range []*checkedSource{left, right} {
+			if len(src.bf) > 0 && !detectAny(src.bf, ids[i]) {
+				missing[src] = append(missing[src], ids[i])
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, src := range []*checkedSource{left, right} {
+		ids := missing[src]
+		if len(ids) == 0 {
+			continue
+		}
+		examples := ids
+		if len(examples) > 5 {
+			examples = examples[:5]
+		}
+		if len(ids) == sampled {
+			report.errorf("mapping_ids", name, "none of %d sampled %s identifiers are in its index, e.g. %s",
+				sampled, src.name, strings.Join(examples, ", "))
+		} else {
+			report.warnf("mapping_ids", name, "%d of %d sampled %s identifiers are not in its index, e.g. %s",
+				len(ids), sampled, src.name, strings.Join(examples, ", "))
+		}
+	}
+	return nil
+}
+
+// detectAny is true if the identifier is in any subset of a source.
+func detectAny(bfs []*sources.BloomFilter, ident string) bool {
+	for _, bf := range bfs {
+		if found, _ := bf.Detect(ident); found {
+			return true
+		}
+	}
+	return false
+}
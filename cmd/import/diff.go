@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// diffSource holds the metadata fields of a source, in sourceFields order.
+type diffSource [5]string
+
+var sourceFields = []string{"description", "ident_type", "url", "id_url", "citedata"}
+
+// diffIndex describes a subset index of a source.
+type diffIndex struct {
+	updated string
+	count   int64
+	bloom   string
+}
+
+// diffMap describes a mapping table between two sources.
+type diffMap struct {
+	left, right string
+	table       string
+	count       int64
+}
+
+// tsvEscaper keeps values on one line of a detail file.
+var tsvEscaper = strings.NewReplacer("\t", " ", "\r", "", "\n", " ")
+
+// diffDetails writes tab-separated detail files into a directory, or
+// nothing if the directory is blank.
+type diffDetails struct {
+	dir   string
+	files []*os.File
+	err   error
+}
+
+// create a detail file with a header row.
+func (d *diffDetails) create(name string, header ...string) *os.File {
+	if d.dir == "" || d.err != nil {
+		return nil
+	}
+	if d.err = os.MkdirAll(d.dir, 0755); d.err != nil {
+		return nil
+	}
+	f, err := os.Create(filepath.Join(d.dir, name))
+	if err != nil {
+		d.err = err
+		return nil
+	}
+	d.files = append(d.files, f)
+	d.write(f, header...)
+	return f
+}
+
+// write a row to a detail file, if it was created.
+func (d *diffDetails) write(f *os.File, vals ...string) {
+	if f == nil || d.err != nil {
+		return
+	}
+	for i, v := range vals {
+		vals[i] = tsvEscaper.Replace(v)
+	}
+	_, d.err = fmt.Fprintln(f, strings.Join(vals, "\t"))
+}
+
+func (d *diffDetails) Close() error {
+	for _, f := range d.files {
+		if err := f.Close(); err != nil && d.err == nil {
+			d.err = err
+		}
+	}
+	return d.err
+}
+
+// diffDatabases compares two builds of the source database, printing a
+// summary of the changes to sources, indexes and mappings. If outDir is
+// given, the changes are detailed in TSV files written there.
+func diffDatabases(oldFilename, newFilename, outDir string) error {
+	for _, fn := range []string{oldFilename, newFilename} {
+		if _, err := os.Stat(fn); err != nil {
+			return err
+		}
+	}
+	db, err := sql.Open("sqlite3", oldFilename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// the attached database is only visible to the one connection
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(`ATTACH DATABASE ? AS new;`, newFilename); err != nil {
+		return err
+	}
+
+	details := &diffDetails{dir: outDir}
+	if err = diffSources(db, details); err != nil {
+		details.Close()
+		return err
+	}
+	if err = diffIndexes(db, details); err != nil {
+		details.Close()
+		return err
+	}
+	if err = diffMappings(db, details); err != nil {
+		details.Close()
+		return err
+	}
+	return details.Close()
+}
+
+func readDiffSources(db *sql.DB, schema string) (map[string]diffSource, error) {
+	rows, err := db.Query(`SELECT name,description,ident_type,url,id_url,citedata FROM ` + schema + `.sources;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[string]diffSource)
+	for rows.Next() {
+		var name string
+		var fields [5]sql.NullString
+		err = rows.Scan(&name, &fields[0], &fields[1], &fields[2], &fields[3], &fields[4])
+		if err != nil {
+			return nil, err
+		}
+		var s diffSource
+		for i, f := range fields {
+			s[i] = f.String
+		}
+		res[name] = s
+	}
+	return res, rows.Err()
+}
+
+func diffSources(db *sql.DB, details *diffDetails) error {
+	oldSrcs, err := readDiffSources(db, "main")
+	if err != nil {
+		return err
+	}
+	newSrcs, err := readDiffSources(db, "new")
+	if err != nil {
+		return err
+	}
+	f := details.create("sources.tsv", "source", "change", "field", "old", "new")
+	for _, name := range unionKeys(oldSrcs, newSrcs) {
+		o, inOld := oldSrcs[name]
+		n, inNew := newSrcs[name]
+		switch {
+		case !inOld:
+			fmt.Printf("source\t%s\tadded\n", name)
+			details.write(f, name, "added", "", "", "")
+		case !inNew:
+			fmt.Printf("source\t%s\tremoved\n", name)
+			details.write(f, name, "removed", "", "", "")
+		default:
+			var changed []string
+			for i, field := range sourceFields {
+				if o[i] != n[i] {
+					changed = append(changed, field)
+					details.write(f, name, "changed", field, o[i], n[i])
+				}
+			}
+			if len(changed) > 0 {
+				fmt.Printf("source\t%s\tchanged %s\n", name, strings.Join(changed, ", "))
+			}
+		}
+	}
+	return details.err
+}
+
+func readDiffIndexes(db *sql.DB, schema string) (map[string]*diffIndex, error) {
+	rows, err := db.Query(`SELECT s.name,i.subset,i.last_update,i.element_count,i.bloom
+		FROM ` + schema + `.sources s, ` + schema + `.source_indexes i WHERE s.source_id=i.source_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[string]*diffIndex)
+	for rows.Next() {
+		var name, subset string
+		var updated sql.NullString
+		var count sql.NullInt64
+		var data []byte
+		if err = rows.Scan(&name, &subset, &updated, &count, &data); err != nil {
+			return nil, err
+		}
+		idx := &diffIndex{updated: updated.String, count: count.Int64}
+		bf := &sources.BloomFilter{}
+		if err = bf.Unpack(data); err != nil {
+			return nil, fmt.Errorf("%s[%s]: %v", name, subset, err)
+		}
+		idx.bloom = bf.ShortString()
+		res[name+"["+subset+"]"] = idx
+	}
+	return res, rows.Err()
+}
+
+func diffIndexes(db *sql.DB, details *diffDetails) error {
+	oldIdx, err := readDiffIndexes(db, "main")
+	if err != nil {
+		return err
+	}
+	newIdx, err := readDiffIndexes(db, "new")
+	if err != nil {
+		return err
+	}
+	f := details.create("indexes.tsv", "index", "change", "old_update", "new_update",
+		"old_count", "new_count", "old_bloom", "new_bloom")
+	for _, name := range unionKeys(oldIdx, newIdx) {
+		o, inOld := oldIdx[name]
+		n, inNew := newIdx[name]
+		switch {
+		case !inOld:
+			fmt.Printf("index\t%s\tadded\t%d items\t%s\n", name, n.count, n.bloom)
+			details.write(f, name, "added", "", n.updated, "", fmt.Sprint(n.count), "", n.bloom)
+		case !inNew:
+			fmt.Printf("index\t%s\tremoved\t%d items\t%s\n", name, o.count, o.bloom)
+			details.write(f, name, "removed", o.updated, "", fmt.Sprint(o.count), "", o.bloom, "")
+		case o.count != n.count || o.bloom != n.bloom || o.updated != n.updated:
+			fmt.Printf("index\t%s\tchanged\t%d => %d items (%+d)\t%s => %s\tupdated %s => %s\n",
+				name, o.count, n.count, n.count-o.count, o.bloom, n.bloom, o.updated, n.updated)
+			details.write(f, name, "changed", o.updated, n.updated,
+				fmt.Sprint(o.count), fmt.Sprint(n.count), o.bloom, n.bloom)
+		}
+	}
+	return details.err
+}
+
+func readDiffMappings(db *sql.DB, schema string) (map[string]*diffMap, error) {
+	rows, err := db.Query(`SELECT l.name,r.name,m.left_source_id,m.right_source_id,m.element_count
+		FROM ` + schema + `.source_mappings m, ` + schema + `.sources l, ` + schema + `.sources r
+		WHERE m.left_source_id=l.source_id AND m.right_source_id=r.source_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[string]*diffMap)
+	for rows.Next() {
+		m := &diffMap{}
+		var leftID, rightID int64
+		var count sql.NullInt64
+		if err = rows.Scan(&m.left, &m.right, &leftID, &rightID, &count); err != nil {
+			return nil, err
+		}
+		m.table = fmt.Sprintf("%s.mapping_%d_to_%d", schema, leftID, rightID)
+		m.count = count.Int64
+		// source IDs differ between builds, so mappings are matched by name
+		key := m.left + "<>" + m.right
+		if m.right < m.left {
+			key = m.right + "<>" + m.left
+		}
+		res[key] = m
+	}
+	return res, rows.Err()
+}
+
+func diffMappings(db *sql.DB, details *diffDetails) error {
+	oldMaps, err := readDiffMappings(db, "main")
+	if err != nil {
+		return err
+	}
+	newMaps, err := readDiffMappings(db, "new")
+	if err != nil {
+		return err
+	}
+	for _, name := range unionKeys(oldMaps, newMaps) {
+		o, inOld := oldMaps[name]
+		n, inNew := newMaps[name]
+		switch {
+		case !inOld:
+			fmt.Printf("mapping\t%s<>%s\tadded\t%d pairs\n", n.left, n.right, n.count)
+		case !inNew:
+			fmt.Printf("mapping\t%s<>%s\tremoved\t%d pairs\n", o.left, o.right, o.count)
+		default:
+			if err = diffMapping(db, o, n, details); err != nil {
+				return err
+			}
+		}
+	}
+	return details.err
+}
+
+// diffMapping compares the pairs of a mapping in both builds, oriented as
+// in the new build.
+func diffMapping(db *sql.DB, o, n *diffMap, details *diffDetails) error {
+	oldPairs := "SELECT left_id,right_id FROM " + o.table
+	if o.left != n.left {
+		oldPairs = "SELECT right_id,left_id FROM " + o.table
+	}
+	with := fmt.Sprintf(`WITH o(l,r) AS (%s), n(l,r) AS (SELECT left_id,right_id FROM %s),
+		added(l,r) AS (SELECT l,r FROM n EXCEPT SELECT l,r FROM o),
+		removed(l,r) AS (SELECT l,r FROM o EXCEPT SELECT l,r FROM n) `, oldPairs, n.table)
+
+	var oldCount, newCount, added, removed int64
+	err := db.QueryRow(with+`SELECT (SELECT COUNT(*) FROM o), (SELECT COUNT(*) FROM n),
+		(SELECT COUNT(*) FROM added), (SELECT COUNT(*) FROM removed);`).Scan(&oldCount, &newCount, &added, &removed)
+	if err != nil {
+		return err
+	}
+	if added == 0 && removed == 0 {
+		return nil
+	}
+	fname := n.left + "_" + n.right
+	if details.dir != "" {
+		f := details.create(fname+".tsv", "change", n.left, n.right)
+		for _, change := range []string{"added", "removed"} {
+			rows, err := db.Query(with + `SELECT l,r FROM ` + change + ` ORDER BY l,r;`)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var l, r string
+				if err = rows.Scan(&l, &r); err != nil {
+					rows.Close()
+					return err
+				}
+				details.write(f, change, l, r)
+			}
+			rows.Close()
+		}
+	}
+
+	// identifiers in both builds which are mapped to different targets
+	f := details.create(fname+".changed.tsv", "source", "id", "old_targets", "new_targets")
+	var changed []string
+	for _, side := range []struct{ name, id, target string }{{n.left, "l", "r"}, {n.right, "r", "l"}} {
+		rows, err := db.Query(with + fmt.Sprintf(`, ch(id) AS (SELECT %[1]s FROM added UNION SELECT %[1]s FROM removed)
+			SELECT id, (SELECT GROUP_CONCAT(%[2]s) FROM o WHERE o.%[1]s=ch.id),
+				(SELECT GROUP_CONCAT(%[2]s) FROM n WHERE n.%[1]s=ch.id)
+			FROM ch WHERE id IN (SELECT %[1]s FROM o) AND id IN (SELECT %[1]s FROM n) ORDER BY id;`,
+			side.id, side.target))
+		if err != nil {
+			return err
+		}
+		nchanged := 0
+		for rows.Next() {
+			var id, oldTargets, newTargets string
+			if err = rows.Scan(&id, &oldTargets, &newTargets); err != nil {
+				rows.Close()
+				return err
+			}
+			nchanged++
+			details.write(f, side.name, id, oldTargets, newTargets)
+		}
+		rows.Close()
+		changed = append(changed, fmt.Sprintf("%d %s IDs", nchanged, side.name))
+	}
+
+	fmt.Printf("mapping\t%s<>%s\tchanged\t%d => %d pairs (%d added, %d removed)\t%s changed targets\n",
+		n.left, n.right, oldCount, newCount, added, removed, strings.Join(changed, ", "))
+	return details.err
+}
+
+// unionKeys returns the sorted keys of both maps.
+func unionKeys(a, b interface{}) []string {
+	seen := make(map[string]bool)
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			seen[k.String()] = true
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
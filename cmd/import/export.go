@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// exportedSource is the metadata of a source written by export. The field
+// names follow the manifest (see applyManifest).
+type exportedSource struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	IDURL       string `json:"id_url"`
+
+	// RIS names the exported citation file, relative to sources.json.
+	RIS string `json:"ris,omitempty"`
+
+	Deprecated bool `json:"deprecated,omitempty"`
+
+	Indexes  []*exportedIndex   `json:"indexes"`
+	Mappings []*exportedMapping `json:"mappings"`
+}
+
+// exportedIndex describes a subset index. The identifiers can't be
+// recovered from the Bloom filter, so only its parameters are exported.
+type exportedIndex struct {
+	Subset  string `json:"subset"`
+	Updated string `json:"updated"`
+	Count   int64  `json:"count"`
+	Bloom   string `json:"bloom"`
+}
+
+// exportedMapping describes a mapping from the source to another.
+type exportedMapping struct {
+	// File names the exported pairs, relative to sources.json.
+	File    string `json:"file"`
+	To      string `json:"to"`
+	Updated string `json:"updated"`
+	Count   int64  `json:"count"`
+
+	// Source is the name of the file the mapping was imported from.
+	Source string `json:"source_file"`
+}
+
+// exportFilenameUnsafe matches characters not used in exported filenames.
+var exportFilenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// exportDatabase writes the contents of the source database into dir as
+// flat files: source metadata in sources.json, citations in refs/*.ris,
+// and the pairs of each mapping in mappings/*.tsv with the source names
+// as the header.
+func exportDatabase(db *sql.DB, dir string) error {
+	if dir == "" {
+		return fmt.Errorf("an export directory is required (-o dir)")
+	}
+	for _, sub := range []string{"refs", "mappings"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	srcs, byID, err := exportSources(db, dir)
+	if err != nil {
+		return err
+	}
+	if err = exportIndexes(db, byID); err != nil {
+		return err
+	}
+	if err = exportMappings(db, dir, byID); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(srcs, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	err = ioutil.WriteFile(filepath.Join(dir, "sources.json"), data, 0644)
+	if err == nil {
+		log.Printf("exported %d sources to %s", len(srcs), dir)
+	}
+	return err
+}
+
+func exportSources(db *sql.DB, dir string) ([]*exportedSource, map[int64]*exportedSource, error) {
+	if err := createDeprecations(db); err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query(`SELECT s.source_id,s.name,s.description,s.ident_type,s.url,s.id_url,s.citedata,
+		d.source_id IS NOT NULL FROM sources s LEFT JOIN source_deprecations d ON s.source_id=d.source_id
+		ORDER BY s.name;`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var srcs []*exportedSource
+	byID := make(map[int64]*exportedSource)
+	for rows.Next() {
+		var id int64
+		var fields [5]sql.NullString
+		s := &exportedSource{Indexes: []*exportedIndex{}, Mappings: []*exportedMapping{}}
+		err = rows.Scan(&id, &s.Name, &fields[0], &fields[1], &fields[2], &fields[3], &fields[4], &s.Deprecated)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.Description = fields[0].String
+		s.Type = fields[1].String
+		s.URL = fields[2].String
+		s.IDURL = fields[3].String
+		if fields[4].String != "" {
+			s.RIS = "refs/" + exportFilenameUnsafe.ReplaceAllString(s.Name, "_") + ".ris"
+			err = ioutil.WriteFile(filepath.Join(dir, s.RIS), []byte(fields[4].String), 0644)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		srcs = append(srcs, s)
+		byID[id] = s
+	}
+	return srcs, byID, rows.Err()
+}
+
+func exportIndexes(db *sql.DB, byID map[int64]*exportedSource) error {
+	rows, err := db.Query(`SELECT source_id,subset,last_update,element_count,bloom
+		FROM source_indexes ORDER BY source_id,subset;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var updated sql.NullString
+		var count sql.NullInt64
+		var data []byte
+		idx := &exportedIndex{}
+		if err = rows.Scan(&id, &idx.Subset, &updated, &count, &data); err != nil {
+			return err
+		}
+		s, ok := byID[id]
+		if !ok {
+			continue
+		}
+		bf := &sources.BloomFilter{}
+		if err = bf.Unpack(data); err != nil {
+			return fmt.Errorf("%s[%s]: %v", s.Name, idx.Subset, err)
+		}
+		idx.Updated = updated.String
+		idx.Count = count.Int64
+		idx.Bloom = bf.ShortString()
+		s.Indexes = append(s.Indexes, idx)
+	}
+	return rows.Err()
+}
+
+func exportMappings(db *sql.DB, dir string, byID map[int64]*exportedSource) error {
+	type mapping struct {
+		leftID, rightID int64
+		m               *exportedMapping
+	}
+	rows, err := db.Query(`SELECT left_source_id,right_source_id,mapfilename,last_update,element_count
+		FROM source_mappings ORDER BY left_source_id,right_source_id;`)
+	if err != nil {
+		return err
+	}
+	var maps []mapping
+	for rows.Next() {
+		x := mapping{m: &exportedMapping{}}
+		var source, updated sql.NullString
+		var count sql.NullInt64
+		if err = rows.Scan(&x.leftID, &x.rightID, &source, &updated, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		x.m.Source = source.String
+		x.m.Updated = updated.String
+		x.m.Count = count.Int64
+		maps = append(maps, x)
+	}
+	rows.Close()
+
+	for _, x := range maps {
+		left, lok := byID[x.leftID]
+		right, rok := byID[x.rightID]
+		if !lok || !rok {
+			log.Printf("skipping mapping_%d_to_%d of a source which doesn't exist", x.leftID, x.rightID)
+			continue
+		}
+		x.m.To = right.Name
+		x.m.File = "mappings/" + exportFilenameUnsafe.ReplaceAllString(left.Name+"_"+right.Name, "_") + ".tsv"
+		n, err := exportPairs(db, filepath.Join(dir, x.m.File), x.leftID, x.rightID, left.Name, right.Name)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s<>%s :: %s = %d pairs exported", left.Name, right.Name, x.m.File, n)
+		left.Mappings = append(left.Mappings, x.m)
+	}
+	return nil
+}
+
+// exportPairs writes the pairs of a mapping table, and any pair attributes,
+// as TSV.
+func exportPairs(db *sql.DB, filename string, leftID, rightID int64, leftName, rightName string) (int, error) {
+	table := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+	hasAttr, err := tableAttributes(db, "main", table)
+	if err != nil {
+		return 0, err
+	}
+	cols := []string{"left_id", "right_id"}
+	header := []string{leftName, rightName}
+	for _, attr := range sources.PairAttributes {
+		if hasAttr[attr] {
+			cols = append(cols, attr)
+			header = append(header, attr)
+		}
+	}
+	rows, err := db.Query(`SELECT ` + strings.Join(cols, ",") + ` FROM ` + table + `
+		ORDER BY left_id,right_id;`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	f, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	n := 0
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	strs := make([]string, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		for i, v := range vals {
+			strs[i] = v.String
+		}
+		fmt.Fprintln(w, strings.Join(strs, "\t"))
+		n++
+	}
+	if err = rows.Err(); err != nil {
+		return n, err
+	}
+	if err = w.Flush(); err != nil {
+		return n, err
+	}
+	return n, f.Close()
+}
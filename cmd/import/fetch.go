@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// fetcher downloads upstream data files into a cache directory laid out as
+// host/path, so that the cache can itself be served as a mirror of the
+// upstream sources.
+type fetcher struct {
+	// cache is the directory of downloaded files.
+	cache string
+
+	// base is the URL of a mirror used instead of the upstream hosts, e.g.
+	// a local file server of another cache.
+	base string
+
+	client *http.Client
+}
+
+// download is a single upstream data file.
+type download struct {
+	URL string
+
+	// File is the path in the cache, host/path of the URL by default.
+	File string
+}
+
+// fetchInfo is recorded alongside each cached file.
+type fetchInfo struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// adapter describes the upstream layout of a source: the files to download
+// and how to index and map them.
+type adapter struct {
+	downloads []*download
+
+	// delay between requests, for rate-limited services
+	delay time.Duration
+
+	// sources with filenames relative to the cache
+	sources []*manifestSource
+}
+
+// file returns the path of the download in the cache.
+func (d *download) file() string {
+	if d.File != "" {
+		return d.File
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return d.URL
+	}
+	return path.Join(u.Host, u.Path)
+}
+
+// Updated returns the upstream timestamp of the file, or the time it was
+// fetched if the upstream didn't report one.
+func (fi *fetchInfo) Updated() time.Time {
+	if t, err := http.ParseTime(fi.LastModified); err == nil {
+		return t.UTC()
+	}
+	return fi.Fetched
+}
+
+// fetchSources downloads the data files of each named adapter, then imports
+// its sources with last_update set from the upstream timestamps.
+func fetchSources(db *sql.DB, f *fetcher, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("supported fetch sources: %s", strings.Join(adapterNames(), ", "))
+	}
+	for _, name := range names {
+		a, ok := fetchAdapters()[name]
+		if !ok {
+			return fmt.Errorf("unknown fetch source %q (supported: %s)", name, strings.Join(adapterNames(), ", "))
+		}
+
+		var updated time.Time
+		for i, d := range a.downloads {
+			if i > 0 {
+				time.Sleep(a.delay)
+			}
+			info, err := f.fetch(d)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if info.Updated().After(updated) {
+				updated = info.Updated()
+			}
+		}
+
+		stamp := updated.Format("2006-01-02T15:04:05")
+		for _, src := range a.sources {
+			src.Updated = stamp
+		}
+		if err := applySources(db, f.cache, &manifest{Sources: a.sources}, stamp); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// fetch downloads a file into the cache unless the cached copy is current.
+func (f *fetcher) fetch(d *download) (*fetchInfo, error) {
+	filename := filepath.Join(f.cache, filepath.FromSlash(d.file()))
+	old, err := readFetchInfo(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	src := d.URL
+	if f.base != "" {
+		src = strings.TrimSuffix(f.base, "/") + "/" + d.file()
+	}
+	req, err := http.NewRequest("GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "databio-import")
+	if old != nil {
+		if old.ETag != "" {
+			req.Header.Set("If-None-Match", old.ETag)
+		}
+		if old.LastModified != "" {
+			req.Header.Set("If-Modified-Since", old.LastModified)
+		}
+	}
+	client := f.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && old != nil {
+		log.Printf("fetch %s not modified", src)
+		return old, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", src, resp.Status)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".fetch-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %v", src, err)
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return nil, err
+	}
+
+	info := &fetchInfo{
+		URL:          src,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       hex.EncodeToString(h.Sum(nil)),
+		Size:         n,
+		Fetched:      time.Now().UTC().Truncate(time.Second),
+	}
+	if info.LastModified != "" {
+		// a file server of the cache then reports the upstream timestamp
+		os.Chtimes(filename, info.Updated(), info.Updated())
+	}
+	log.Printf("fetch %s = %d bytes (sha256 %s)", src, n, info.SHA256)
+	return info, writeFetchInfo(filename, info)
+}
+
+// readFetchInfo returns the recorded info of a cached file, or nil if the
+// file is missing or doesn't match its checksum.
+func readFetchInfo(filename string) (*fetchInfo, error) {
+	data, err := ioutil.ReadFile(filename + ".fetch.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := &fetchInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("%s.fetch.json: %v", filename, err)
+	}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != info.SHA256 {
+		log.Printf("%s doesn't match its checksum, fetching again", filename)
+		return nil, nil
+	}
+	return info, nil
+}
+
+func writeFetchInfo(filename string, info *fetchInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename+".fetch.json", data, 0644)
+}
+
+func adapterNames() []string {
+	var names []string
+	for name := range fetchAdapters() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fetchAdapters returns the built-in adapters by name. The upstream URLs
+// and layouts are the same as the quickstart scripts.
+func fetchAdapters() map[string]*adapter {
+	const ncbiData = "https://ftp.ncbi.nlm.nih.gov/gene/DATA/"
+	gene2ensembl := &download{URL: ncbiData + "gene2ensembl.gz"}
+	// the first column is named "#tax_id"
+	gene2ensemblTaxon := map[string]string{sources.AttrTaxon: "1"}
+	hgnc := &download{
+		URL:  "https://www.genenames.org/cgi-bin/download/custom?col=gd_hgnc_id&col=gd_app_sym&col=gd_app_name&col=gd_pub_refseq_ids&col=gd_pub_eg_id&col=gd_pub_ensembl_id&status=Approved&hgnc_dbtag=on&order_by=gd_app_sym_sort&format=text&submit=submit",
+		File: "www.genenames.org/hgnc.tsv",
+	}
+	geneInfo := func(path string) *download {
+		return &download{URL: ncbiData + "GENE_INFO/" + path}
+	}
+	humanInfo := geneInfo("Mammalia/Homo_sapiens.gene_info.gz")
+	mammalInfo := geneInfo("Mammalia/All_Mammalia.gene_info.gz")
+	plantInfo := geneInfo("Plants/All_Plants.gene_info.gz")
+	mim2gene := &download{URL: "https://omim.org/static/omim/data/mim2gene.txt"}
+
+	ncbi := &adapter{
+		downloads: []*download{gene2ensembl, humanInfo, mammalInfo, plantInfo},
+		sources: []*manifestSource{{
+			Name:        "gov.nih.nlm.ncbi.gene",
+			Description: "NCBI Entrez Gene ID",
+			Type:        "integer",
+			URL:         "https://www.ncbi.nlm.nih.gov/gene",
+			IDURL:       "https://www.ncbi.nlm.nih.gov/gene/%s",
+			Indexes: []*manifestIndex{
+				{manifestTable: manifestTable{File: gene2ensembl.file()}, Column: "GeneID"},
+				{manifestTable: manifestTable{File: humanInfo.file()}, Subset: "human", Column: "GeneID"},
+				{manifestTable: manifestTable{File: mammalInfo.file()}, Subset: "mammals", Column: "GeneID"},
+				{manifestTable: manifestTable{File: plantInfo.file()}, Subset: "plants", Column: "GeneID"},
+			},
+			Mappings: []*manifestMapping{
+				{manifestTable: manifestTable{File: gene2ensembl.file()}, To: "org.ensembl.gene",
+					Left: "GeneID", Right: "Ensembl_gene_identifier", Attributes: gene2ensemblTaxon},
+				{manifestTable: manifestTable{File: gene2ensembl.file()}, To: "org.ensembl.transcript",
+					Left: "GeneID", Right: "Ensembl_rna_identifier", Attributes: gene2ensemblTaxon},
+				{manifestTable: manifestTable{File: gene2ensembl.file()}, To: "org.ensembl.protein",
+					Left: "GeneID", Right: "Ensembl_protein_identifier", Attributes: gene2ensemblTaxon},
+			},
+		}},
+	}
+
+	hgncTable := manifestTable{File: hgnc.file()}
+	hgncSource := func(name, desc, idURL, column string) *manifestSource {
+		return &manifestSource{
+			Name:        name,
+			Description: desc,
+			URL:         "http://www.genenames.org/",
+			IDURL:       idURL,
+			Indexes:     []*manifestIndex{{manifestTable: hgncTable, Column: column}},
+		}
+	}
+	const hgncSearch = "https://www.genenames.org/tools/search/#!/all?query=%s"
+	hgncGene := hgncSource("org.genenames.gene", "HGNC Gene ID",
+		"http://www.genenames.org/cgi-bin/gene_symbol_report?hgnc_id=%s", "HGNC ID")
+	for _, m := range [][2]string{
+		{"org.genenames.symbol", "Approved symbol"},
+		{"org.genenames.name", "Approved name"},
+		{"gov.nih.nlm.ncbi.gene", "NCBI Gene ID"},
+		{"org.ensembl.gene", "Ensembl gene ID"},
+	} {
+		hgncGene.Mappings = append(hgncGene.Mappings, &manifestMapping{
+			manifestTable: hgncTable, To: m[0], Left: "HGNC ID", Right: m[1]})
+	}
+
+	omimTable := func(where string) manifestTable {
+		return manifestTable{
+			File:     mim2gene.file(),
+			NoHeader: true,
+			Comment:  "#",
+			Where:    map[string]string{"2": where},
+		}
+	}
+	omim := &adapter{
+		downloads: []*download{mim2gene},
+		sources: []*manifestSource{{
+			Name:        "org.omim.gene",
+			Description: "OMIM Gene ID",
+			Type:        "integer",
+			URL:         "https://omim.org",
+			IDURL:       "http://omim.org/entry/%s",
+			Indexes:     []*manifestIndex{{manifestTable: omimTable("gene"), Column: "1"}},
+			Mappings: []*manifestMapping{
+				{manifestTable: omimTable("gene"), To: "gov.nih.nlm.ncbi.gene", Left: "1", Right: "3"},
+				{manifestTable: omimTable("gene"), To: "org.ensembl.gene", Left: "1", Right: "5"},
+			},
+		}},
+	}
+
+	ensemblSource := func(name, desc string, indexes ...*manifestIndex) *manifestSource {
+		return &manifestSource{
+			Name:        name,
+			Description: desc,
+			URL:         "https://ensembl.org",
+			IDURL:       "http://www.ensembl.org/id/%s",
+			Indexes:     indexes,
+		}
+	}
+	g2eTable := manifestTable{File: gene2ensembl.file()}
+	ensembl := &adapter{
+		downloads: []*download{gene2ensembl, hgnc},
+		sources: []*manifestSource{
+			ensemblSource("org.ensembl.gene", "Ensembl Gene ID",
+				&manifestIndex{manifestTable: g2eTable, Column: "Ensembl_gene_identifier"},
+				&manifestIndex{manifestTable: hgncTable, Subset: "human", Column: "Ensembl gene ID"}),
+			ensemblSource("org.ensembl.transcript", "Ensembl Transcript ID",
+				&manifestIndex{manifestTable: g2eTable, Column: "Ensembl_rna_identifier"}),
+			ensemblSource("org.ensembl.protein", "Ensembl Protein ID",
+				&manifestIndex{manifestTable: g2eTable, Column: "Ensembl_protein_identifier"}),
+		},
+	}
+
+	return map[string]*adapter{
+		"ncbi": ncbi,
+		"hgnc": {
+			downloads: []*download{hgnc},
+			sources: []*manifestSource{
+				hgncGene,
+				hgncSource("org.genenames.symbol", "HGNC Gene Symbol", hgncSearch, "Approved symbol"),
+				hgncSource("org.genenames.name", "HGNC Gene Name", hgncSearch, "Approved name"),
+			},
+		},
+		"kegg":    keggAdapter(),
+		"omim":    omim,
+		"ensembl": ensembl,
+	}
+}
+
+// keggAdapter fetches the genes of each organism from the KEGG REST API,
+// which is limited to a few requests per second.
+func keggAdapter() *adapter {
+	// slightly friendlier names than the 3-letter codes
+	organisms := [][2]string{
+		{"aga", "Anopheles"}, {"ath", "Arabidopsis"}, {"bta", "Bovine"}, {"cel", "Worm"},
+		{"cfa", "Canine"}, {"dme", "Fly"}, {"dre", "Zebrafish"}, {"eco", "E coli strain K12"},
+		{"ecs", "E coli strain Sakai"}, {"gga", "Chicken"}, {"hsa", "Human"}, {"mmu", "Mouse"},
+		{"mcc", "Rhesus"}, {"pfa", "Malaria"}, {"ptr", "Chimp"}, {"rno", "Rat"},
+		{"sce", "Yeast"}, {"ssc", "Pig"}, {"xla", "Xenopus"},
+	}
+	const rest = "https://rest.kegg.jp/"
+	kegg := &manifestSource{
+		Name:        "jp.kegg.gene",
+		Description: "KEGG Gene ID",
+		URL:         "https://kegg.jp",
+		IDURL:       "https://www.genome.jp/dbget-bin/www_bget?%s",
+	}
+	ncbiTable := manifestTable{NoHeader: true, TrimPrefix: map[string]string{"1": "ncbi-geneid:"}}
+	uniprotTable := manifestTable{NoHeader: true, TrimPrefix: map[string]string{"1": "up:"}}
+	a := &adapter{delay: time.Second}
+	for _, org := range organisms {
+		list := &download{URL: rest + "list/" + org[0], File: "rest.kegg.jp/list/" + org[0] + ".tsv"}
+		ncbi := &download{URL: rest + "conv/" + org[0] + "/ncbi-geneid",
+			File: "rest.kegg.jp/conv/" + org[0] + "/ncbi-geneid.tsv"}
+		uniprot := &download{URL: rest + "conv/" + org[0] + "/uniprot",
+			File: "rest.kegg.jp/conv/" + org[0] + "/uniprot.tsv"}
+		a.downloads = append(a.downloads, list, ncbi, uniprot)
+
+		kegg.Indexes = append(kegg.Indexes, &manifestIndex{
+			manifestTable: manifestTable{File: list.file(), NoHeader: true},
+			Subset:        org[1],
+			Column:        "1",
+		})
+		ncbiTable.Files = append(ncbiTable.Files, ncbi.file())
+		uniprotTable.Files = append(uniprotTable.Files, uniprot.file())
+	}
+	// the first file of each table is listed in File
+	ncbiTable.File, ncbiTable.Files = ncbiTable.Files[0], ncbiTable.Files[1:]
+	uniprotTable.File, uniprotTable.Files = uniprotTable.Files[0], uniprotTable.Files[1:]
+
+	a.sources = []*manifestSource{
+		kegg,
+		{
+			Name:     "gov.nih.nlm.ncbi.gene",
+			Mappings: []*manifestMapping{{manifestTable: ncbiTable, To: "jp.kegg.gene"}},
+		},
+		{
+			Name:        "org.uniprot.acc",
+			Description: "UniprotKB Accession",
+			URL:         "https://www.uniprot.org",
+			IDURL:       "https://www.uniprot.org/uniprot/%s",
+			Indexes:     []*manifestIndex{{manifestTable: uniprotTable, Column: "1"}},
+			Mappings:    []*manifestMapping{{manifestTable: uniprotTable, To: "jp.kegg.gene"}},
+		},
+	}
+	return a
+}
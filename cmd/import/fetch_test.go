@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchSources(t *testing.T) {
+	const mim2gene = "# Copyright (c) 1966-2024 Johns Hopkins University\n" +
+		"# MIM Number\tMIM Entry Type\tEntrez Gene ID (NCBI)\tApproved Gene Symbol (HGNC)\tEnsembl Gene ID (Ensembl)\n" +
+		"100050\tpredominantly phenotypes\t\t\t\n" +
+		"100640\tgene\t216\tALDH1A1\tENSG00000165092\n" +
+		"100660\tgene\t218\tALDH3A1\tENSG00000108602\n"
+	const lastModified = "Tue, 02 Jan 2024 03:04:05 GMT"
+
+	var served, notModified int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/omim.org/static/omim/data/mim2gene.txt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		served++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte(mim2gene))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	db := openTestDB(t)
+	f := &fetcher{cache: t.TempDir(), base: srv.URL}
+	cached := filepath.Join(f.cache, "omim.org", "static", "omim", "data", "mim2gene.txt")
+
+	type state struct {
+		info          fetchInfo
+		updated       string
+		items, pairs  int
+		ncbi, ensembl string
+	}
+	current := func() state {
+		t.Helper()
+		var s state
+		data, err := ioutil.ReadFile(cached + ".fetch.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(data, &s.info); err != nil {
+			t.Fatal(err)
+		}
+		err = db.QueryRow(`SELECT CAST(last_update AS TEXT),element_count FROM source_indexes`).Scan(&s.updated, &s.items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.QueryRow(`SELECT SUM(element_count) FROM source_mappings`).Scan(&s.pairs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.QueryRow(`SELECT GROUP_CONCAT(right_id) FROM (SELECT right_id FROM mapping_1_to_2 ORDER BY right_id)`).Scan(&s.ncbi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.QueryRow(`SELECT GROUP_CONCAT(right_id) FROM (SELECT right_id FROM mapping_1_to_3 ORDER BY right_id)`).Scan(&s.ensembl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if err := fetchSources(db, f, []string{"omim"}); err != nil {
+		t.Fatal(err)
+	}
+	first := current()
+	if served != 1 {
+		t.Errorf("served %d downloads, want 1", served)
+	}
+	if first.info.ETag != `"v1"` || first.info.LastModified != lastModified || first.info.Size != int64(len(mim2gene)) {
+		t.Errorf("fetch info = %+v", first.info)
+	}
+	if first.updated != "2024-01-02T03:04:05" {
+		t.Errorf("last_update = %q, want the upstream Last-Modified", first.updated)
+	}
+	if first.items != 2 || first.pairs != 4 || first.ncbi != "216,218" || first.ensembl != "ENSG00000108602,ENSG00000165092" {
+		t.Errorf("imported %+v", first)
+	}
+
+	if err := fetchSources(db, f, []string{"omim"}); err != nil {
+		t.Fatal(err)
+	}
+	if served != 1 || notModified != 1 {
+		t.Errorf("second fetch served %d downloads and %d not modified, want 1 and 1", served, notModified)
+	}
+	if second := current(); second != first {
+		t.Errorf("second fetch changed %+v to %+v", first, second)
+	}
+}
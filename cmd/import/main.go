@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -54,10 +53,25 @@ func initDB(db *sql.DB) error {
 				element_count integer,
 				primary key (left_source_id, right_source_id)
 			);`)
-	return err
+	if err != nil {
+		return err
+	}
+	if err = createHistory(db); err != nil {
+		return err
+	}
+	if err = createDeprecations(db); err != nil {
+		return err
+	}
+	return createReleases(db)
 }
 
-func getOrCreateSource(db *sql.DB, sourceName string) (int64, error) {
+// dbtx is implemented by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func getOrCreateSource(db dbtx, sourceName string) (int64, error) {
 	var sid int64
 	err := db.QueryRow("SELECT source_id FROM sources WHERE name=?;", sourceName).Scan(&sid)
 	if err == sql.ErrNoRows {
@@ -96,44 +110,22 @@ func createReference(db *sql.DB, sourceName, risFilename string) error {
 	return err
 }
 
-func loadIndex(db *sql.DB, sourceName, subsetName, filename, updated string) error {
+func loadIndex(db *sql.DB, sourceName, subsetName, filename, column, updated string) error {
 	srcid, err := getOrCreateSource(db, sourceName)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Open(filename)
+	info, err := os.Stat(filename)
 	if err != nil {
 		return err
 	}
-	info, err := f.Stat()
+	originalSize := info.Size()
+	items, err := readIdentifiers(filename, column)
 	if err != nil {
 		return err
 	}
-	originalSize := info.Size()
-	items := make(map[string]struct{}, 75000)
-	s := bufio.NewScanner(f)
-	first := true
-	for s.Scan() {
-		if first {
-			first = false
-			continue
-		}
-		ident := strings.TrimSpace(s.Text())
-		if ident == "" {
-			continue
-		}
-		items[ident] = struct{}{}
-	}
-	f.Close()
-
-	bf := &sources.BloomFilter{}
-	bf.Advise(len(items))
-	for x := range items {
-		bf.Learn(x)
-	}
-
-	data := bf.Pack()
+	data := packIdentifiers(items)
 	log.Printf("%s[%s] :: %s = %d items indexed (%dkb => %dkb [%d%%])", sourceName, subsetName, filename,
 		len(items), originalSize/1024, len(data)/1024, (len(data)*100)/int(originalSize+1))
 	_, err = db.Exec(`INSERT INTO source_indexes (source_id,subset,last_update,element_count,bloom)
@@ -144,6 +136,33 @@ func loadIndex(db *sql.DB, sourceName, subsetName, filename, updated string) err
 	return err
 }
 
+// readIdentifiers returns the unique identifiers in a column of a file.
+func readIdentifiers(filename, column string) (map[string]struct{}, error) {
+	t, err := openTable(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	items := make(map[string]struct{}, 75000)
+	_, err = t.each([]string{column}, 1, func(vals [][]string) error {
+		for _, ident := range vals[0] {
+			items[ident] = struct{}{}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// packIdentifiers returns the packed Bloom filter of a set of identifiers.
+func packIdentifiers(items map[string]struct{}) []byte {
+	bf := &sources.BloomFilter{}
+	bf.Advise(len(items))
+	for x := range items {
+		bf.Learn(x)
+	}
+	return bf.Pack()
+}
+
 func showStats(db *sql.DB) error {
 	rows, err := db.Query("SELECT s.name,i.subset,i.bloom FROM sources s, source_indexes i WHERE s.source_id=i.source_id;")
 	if err != nil {
@@ -169,7 +188,11 @@ func showStats(db *sql.DB) error {
 	return rows.Close()
 }
 
-func createMapping(db *sql.DB, leftSourceName, rightSourceName, filename, updated string) error {
+func createMapping(db *sql.DB, leftSourceName, rightSourceName, filename, leftColumn, rightColumn string,
+	attrs pairAttributes, updated string) error {
+	if err := attrs.validate(); err != nil {
+		return err
+	}
 	leftID, err := getOrCreateSource(db, leftSourceName)
 	if err != nil {
 		return err
@@ -198,66 +221,99 @@ func createMapping(db *sql.DB, leftSourceName, rightSourceName, filename, update
 		tx.Rollback()
 		return err
 	}
-	_, err = tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS mapping_%d_to_%d (
-			left_id varchar,
-			right_id varchar,
-			primary key(left_id,right_id)
-		);`, leftID, rightID))
-	if err != nil {
+	table := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+	if err = createMappingTable(tx, table, attrs.names()); err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO mapping_%d_to_%d (left_id,right_id)
-		VALUES (?,?) ON CONFLICT DO NOTHING;`, leftID, rightID))
+	// the reverse index is rebuilt after loading
+	_, err = tx.Exec(`DROP INDEX IF EXISTS ` + reverseIndex(leftID, rightID) + `;`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	loader, err := newPairLoader(tx, table, attrs.names())
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	/// read in the entire mapping file
-	f, err := os.Open(filename)
+	n, malformed, err := insertPairs(loader, filename, leftColumn, rightColumn, attrs, swapped)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
-	n := 0
-	s := bufio.NewScanner(f)
-	s.Scan() // skip header
-	for s.Scan() {
-		row := strings.Split(s.Text(), "\t")
-		left := strings.TrimSpace(row[swapped])
-		right := strings.TrimSpace(row[1-swapped])
-		if left == "" || right == "" {
-			// skip any pairs with a blank
-			continue
-		}
-		_, err = stmt.Exec(left, right)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		n++
+	loaded, err := loader.Load()
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
-	f.Close()
-	log.Printf("%s<>%s :: %s = %d pairs mapped", leftSourceName, rightSourceName, filename, n)
+	log.Printf("%s<>%s :: %s = %d pairs mapped, %d new (%d malformed rows)", leftSourceName, rightSourceName,
+		filename, n, loaded, malformed)
 	_, err = tx.Exec(fmt.Sprintf(`UPDATE source_mappings SET element_count=(SELECT COUNT(*) FROM mapping_%d_to_%d)
-		WHERE left_source_id=%d AND right_source_id=%d;`, rightID, leftID, leftID, rightID))
-
-	_, err = tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS mapping_%d_to_%d_idx ON mapping_%d_to_%d (right_id,left_id);`,
-		rightID, leftID, leftID, rightID))
+		WHERE left_source_id=%d AND right_source_id=%d;`, leftID, rightID, leftID, rightID))
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	if err = createReverseIndex(tx, "main", leftID, rightID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
+// insertPairs stages the pairs of identifiers in the left and right
+// columns of a file, swapping them if the left source has the higher ID.
+// Pairs with a blank are skipped, multiple values map to each other. The
+// first value of each pair attribute column is staged with the pairs, or
+// NULL if it is blank.
+func insertPairs(loader *pairLoader, filename, leftColumn, rightColumn string, attrs pairAttributes,
+	swapped int) (n, malformed int, err error) {
+	t, err := openTable(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer t.Close()
+	selectors := append([]string{leftColumn, rightColumn}, attrs.columns()...)
+	attrVals := make([]interface{}, len(selectors)-2)
+	malformed, err = t.each(selectors, 2, func(vals [][]string) error {
+		for i, v := range vals[2:] {
+			attrVals[i] = nil
+			if len(v) > 0 {
+				attrVals[i] = v[0]
+			}
+		}
+		for _, left := range vals[swapped] {
+			for _, right := range vals[1-swapped] {
+				if err := loader.Add(left, right, attrVals...); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	return n, malformed, err
+}
+
 func main() {
 	envSourceDB, ok := os.LookupEnv("DATABIO_DB")
 	if !ok {
 		envSourceDB = "sources.sqlite"
 	}
+	envReleases, ok := os.LookupEnv("DATABIO_RELEASES")
+	if !ok {
+		envReleases = "releases"
+	}
+	envCache, ok := os.LookupEnv("DATABIO_CACHE")
+	if !ok {
+		envCache = "cache"
+	}
 	logfilename, ok := os.LookupEnv("DATABIO_LOGS")
 	if ok {
 		f, err := os.Create(logfilename)
@@ -272,7 +328,25 @@ func main() {
 	coltype := flag.String("t", "text", "`type` of the identifers (integers, floats, prefixed integers, text)")
 	upDate := flag.String("d", "", "`datetime` for the fetch of the updated data")
 	subsetname := flag.String("s", "", "`name` of the subset when indexing (blank=all)")
+	column := flag.String("c", "1", "`column` name or number of the identifiers when indexing")
+	leftColumn := flag.String("l", "1", "`column` name or number of the left identifiers when mapping")
+	rightColumn := flag.String("r", "2", "`column` name or number of the right identifiers when mapping")
+	cacheDir := flag.String("cache", envCache, "`directory` of files downloaded by fetch")
+	baseURL := flag.String("base", "", "`url` of a mirror to fetch from instead of the upstream sources")
+	releaseDir := flag.String("releases", envReleases, "`directory` of release snapshots")
+	sampleSize := flag.Int("sample", 1000, "`number` of mapped pairs to check against the indexes")
+	outDir := flag.String("o", "", "`path` of the export directory, the diff details directory (blank=summary only), or the database built by merge or subset")
+	relColumn := flag.String("rel", "", "`column` name or number of the relationship type of the pairs when mapping")
+	evidenceColumn := flag.String("evidence", "", "`column` name or number of the evidence for the pairs when mapping")
+	taxonColumn := flag.String("taxon", "", "`column` name or number of the taxon of the pairs when mapping")
+	rankColumn := flag.String("rank", "", "`column` name or number of the rank of the pairs when mapping (1=best)")
 	flag.Parse()
+	attrs := pairAttributes{
+		sources.AttrRelationship: *relColumn,
+		sources.AttrEvidence:     *evidenceColumn,
+		sources.AttrTaxon:        *taxonColumn,
+		sources.AttrRank:         *rankColumn,
+	}
 
 	db, err := sql.Open("sqlite3", *dbfile)
 	if err != nil {
@@ -298,17 +372,73 @@ func main() {
 	case "refs", "ref": // reverse.dotted.source.identifier reference.ris
 		err = createReference(db, flag.Arg(1), flag.Arg(2))
 
-	case "index": // [-s subset] reverse.dotted.source.identifier identifier_filename.txt
-		err = loadIndex(db, flag.Arg(1), *subsetname, flag.Arg(2), *upDate)
+	case "deprecate": // reverse.dotted.source.identifier "reason for deprecation"
+		err = deprecateSource(db, flag.Arg(1), flag.Arg(2), true)
+
+	case "undeprecate": // reverse.dotted.source.identifier
+		err = deprecateSource(db, flag.Arg(1), "", false)
+
+	case "index": // [-s subset] [-c column] reverse.dotted.source.identifier identifier_filename.txt[.gz]
+		err = loadIndex(db, flag.Arg(1), *subsetname, flag.Arg(2), *column, *upDate)
+
+	case "map": // [-l column] [-r column] [-rel|-evidence|-taxon|-rank column] reverse.dotted.left.source.identifier reverse.dotted.right.source.identifier mapping_filename.tsv[.gz]
+		err = createMapping(db, flag.Arg(1), flag.Arg(2), flag.Arg(3), *leftColumn, *rightColumn, attrs, *upDate)
+
+	case "update": // index|map [flags and arguments as above]
+		switch flag.Arg(1) {
+		case "index":
+			err = updateIndex(db, flag.Arg(2), *subsetname, flag.Arg(3), *column, *upDate)
+		case "map":
+			err = updateMapping(db, flag.Arg(2), flag.Arg(3), flag.Arg(4), *leftColumn, *rightColumn, attrs, *upDate)
+		default:
+			err = fmt.Errorf("supported updates: index, map")
+		}
+
+	case "rm-source": // reverse.dotted.source.identifier
+		err = removeSource(db, flag.Arg(1))
+
+	case "rm-subset": // reverse.dotted.source.identifier subset
+		err = removeSubset(db, flag.Arg(1), flag.Arg(2))
 
-	case "map": // reverse.dotted.left.source.identifier reverse.dotted.right.source.identifier mapping_filename.tsv
-		err = createMapping(db, flag.Arg(1), flag.Arg(2), flag.Arg(3), *upDate)
+	case "rm-map": // reverse.dotted.left.source.identifier reverse.dotted.right.source.identifier
+		err = removeMapping(db, flag.Arg(1), flag.Arg(2))
+
+	case "apply": // manifest.yaml
+		err = applyManifest(db, flag.Arg(1), *upDate)
+
+	case "fetch": // [-cache dir] [-base url] source...
+		err = fetchSources(db, &fetcher{cache: *cacheDir, base: *baseURL}, flag.Args()[1:])
+
+	case "release": // [-releases dir] release-id "text description of release"
+		err = createRelease(db, *releaseDir, flag.Arg(1), flag.Arg(2))
+
+	case "releases": // [-releases dir]
+		err = listReleases(db, *releaseDir)
+
+	case "check": // [-sample n]
+		err = checkDatabase(db, *sampleSize)
+
+	case "diff": // [-o dir] old.sqlite new.sqlite
+		err = diffDatabases(flag.Arg(1), flag.Arg(2), *outDir)
+
+	case "export": // -o dir
+		err = exportDatabase(db, *outDir)
+
+	case "merge": // a.sqlite b.sqlite... -o out.sqlite
+		err = mergeDatabases(*outDir, positionalArgs())
+
+	case "subset": // source[:subset]... -o out.sqlite
+		var sel buildSelection
+		sel, err = parseSelection(positionalArgs())
+		if err == nil {
+			err = subsetDatabase(*dbfile, *outDir, sel)
+		}
 
 	case "stats":
 		err = showStats(db)
 
 	default:
-		log.Fatal("supported commands: init, new, urls, refs, index, map")
+		log.Fatal("supported commands: init, new, urls, refs, deprecate, undeprecate, index, map, update, rm-source, rm-subset, rm-map, apply, fetch, release, releases, check, diff, export, merge, subset")
 	}
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/joiningdata/databio/sources"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// manifest declares the sources to import, their metadata, and the files
+// used to index identifiers and map between sources.
+//
+//    sources:
+//      - name: org.genenames.gene
+//        description: HGNC Gene ID
+//        type: text
+//        url: http://www.genenames.org/
+//        id_url: http://www.genenames.org/cgi-bin/gene_symbol_report?hgnc_id=%s
+//        ris: hgnc.ris
+//        indexes:
+//          - file: hgnc.tsv
+//            column: HGNC ID
+//        mappings:
+//          - file: hgnc.tsv
+//            to: org.genenames.symbol
+//            left: HGNC ID
+//            right: Approved symbol
+//
+// Filenames are relative to the manifest.
+type manifest struct {
+	Sources []*manifestSource `yaml:"sources"`
+}
+
+type manifestSource struct {
+	// Name is the reverse.dotted.source.identifier.
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+
+	// Type of the identifiers (integers, floats, prefixed integers, text).
+	Type string `yaml:"type"`
+
+	// URL of the source, and IDURL with a '%s' placeholder for identifiers.
+	URL   string `yaml:"url"`
+	IDURL string `yaml:"id_url"`
+
+	// RIS names a file containing the citation for the source.
+	RIS string `yaml:"ris"`
+
+	// Updated is the datetime of the fetch of the data files, if not the
+	// -d flag.
+	Updated string `yaml:"updated"`
+
+	Indexes  []*manifestIndex   `yaml:"indexes"`
+	Mappings []*manifestMapping `yaml:"mappings"`
+}
+
+// manifestTable describes a data file in any of the formats read by the
+// index and map commands. Columns are selected by header name or by 1-based
+// number, as with cut -f.
+type manifestTable struct {
+	File string `yaml:"file"`
+
+	// Files are read after File, as if they were one table.
+	Files []string `yaml:"files"`
+
+	// NoHeader indicates that the first row is data, not column names.
+	NoHeader bool `yaml:"no_header"`
+
+	// Comment is a prefix of the first column of rows to skip.
+	Comment string `yaml:"comment"`
+
+	// Where selects the rows where a value of each column fully matches a
+	// regexp.
+	Where map[string]string `yaml:"where"`
+
+	// TrimPrefix removes a prefix from the values in each column.
+	TrimPrefix map[string]string `yaml:"trim_prefix"`
+}
+
+type manifestIndex struct {
+	manifestTable `yaml:",inline"`
+
+	// Subset name of the index (blank=all).
+	Subset string `yaml:"subset"`
+
+	// Column of identifiers, the first column by default.
+	Column string `yaml:"column"`
+}
+
+type manifestMapping struct {
+	manifestTable `yaml:",inline"`
+
+	// To names the source of the identifiers in the Right column.
+	To string `yaml:"to"`
+
+	// Left and Right columns, the first and second by default.
+	Left  string `yaml:"left"`
+	Right string `yaml:"right"`
+
+	// Attributes selects the column of each pair attribute to load, e.g.
+	// taxon: tax_id (see sources.PairAttributes).
+	Attributes map[string]string `yaml:"attributes"`
+}
+
+// applyManifest imports each source in the manifest in its own transaction,
+// and prints a summary of the changes. Applying the same manifest again
+// changes nothing.
+func applyManifest(db *sql.DB, filename, updated string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	m := &manifest{}
+	if err = yaml.UnmarshalStrict(data, m); err != nil {
+		return err
+	}
+	if err = applySources(db, filepath.Dir(filename), m, updated); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// applySources imports each source in a manifest, with filenames relative
+// to dir.
+func applySources(db *sql.DB, dir string, m *manifest, updated string) error {
+	for _, src := range m.Sources {
+		if src.Name == "" {
+			return fmt.Errorf("source without a name")
+		}
+		changes, err := applySource(db, dir, src, updated)
+		if err != nil {
+			return fmt.Errorf("%s: %v", src.Name, err)
+		}
+		if len(changes) == 0 {
+			fmt.Println(src.Name, "unchanged")
+		}
+		for _, c := range changes {
+			fmt.Println(src.Name, c)
+		}
+	}
+	return nil
+}
+
+// applySource updates the metadata, indexes and mappings of a source, and
+// returns a description of each change.
+func applySource(db *sql.DB, dir string, src *manifestSource, updated string) ([]string, error) {
+	if src.Updated != "" {
+		updated = src.Updated
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := applySourceTx(tx, dir, src, updated)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return changes, tx.Commit()
+}
+
+func applySourceTx(tx *sql.Tx, dir string, src *manifestSource, updated string) ([]string, error) {
+	var changes []string
+	var old struct {
+		description, identType, url, idURL, citedata sql.NullString
+	}
+	err := tx.QueryRow(`SELECT description,ident_type,url,id_url,citedata FROM sources WHERE name=?;`,
+		src.Name).Scan(&old.description, &old.identType, &old.url, &old.idURL, &old.citedata)
+	if err == sql.ErrNoRows {
+		changes = append(changes, "created")
+	} else if err != nil {
+		return nil, err
+	}
+	srcid, err := getOrCreateSource(tx, src.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if src.IDURL != "" && strings.Count(src.IDURL, "%s") != 1 {
+		return nil, fmt.Errorf("the ID URL must have a '%%s' placeholder for the identifier")
+	}
+	var citedata string
+	if src.RIS != "" {
+		b, err := ioutil.ReadFile(filepath.Join(dir, src.RIS))
+		if err != nil {
+			return nil, err
+		}
+		citedata = string(b)
+	}
+	fields := []struct {
+		column, value string
+		old           sql.NullString
+	}{
+		{"description", src.Description, old.description},
+		{"ident_type", src.Type, old.identType},
+		{"url", src.URL, old.url},
+		{"id_url", src.IDURL, old.idURL},
+		{"citedata", citedata, old.citedata},
+	}
+	for _, f := range fields {
+		if f.value == "" || (f.old.Valid && f.old.String == f.value) {
+			continue
+		}
+		_, err = tx.Exec(`UPDATE sources SET `+f.column+`=? WHERE source_id=?;`, f.value, srcid)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, "set "+f.column)
+	}
+
+	for _, idx := range src.Indexes {
+		c, err := applyIndex(tx, dir, srcid, idx, updated)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	for _, mp := range src.Mappings {
+		c, err := applyMapping(tx, dir, srcid, mp, updated)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// applyIndex replaces the index of a subset if the identifiers changed.
+func applyIndex(tx *sql.Tx, dir string, srcid int64, idx *manifestIndex, updated string) (string, error) {
+	column := idx.Column
+	if column == "" {
+		column = "1"
+	}
+	items := make(map[string]struct{}, 75000)
+	err := idx.each(dir, []string{column}, 1, func(vals [][]string) error {
+		for _, ident := range vals[0] {
+			items[ident] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	bf := &sources.BloomFilter{}
+	bf.Advise(len(items))
+	for x := range items {
+		bf.Learn(x)
+	}
+	data := bf.Pack()
+
+	desc := fmt.Sprintf("index [%s] %s", idx.Subset, idx.File)
+	var oldData []byte
+	err = tx.QueryRow(`SELECT bloom FROM source_indexes WHERE source_id=? AND subset=?;`,
+		srcid, idx.Subset).Scan(&oldData)
+	switch {
+	case err == sql.ErrNoRows:
+		desc += fmt.Sprintf(" = %d items indexed", len(items))
+	case err != nil:
+		return "", err
+	case bytes.Equal(oldData, data):
+		return desc + " unchanged", nil
+	default:
+		desc += fmt.Sprintf(" = %d items re-indexed", len(items))
+	}
+
+	_, err = tx.Exec(`INSERT INTO source_indexes (source_id,subset,last_update,element_count,bloom)
+		VALUES (?,?,?,?,?) ON CONFLICT (source_id,subset) DO UPDATE
+		SET last_update=excluded.last_update, element_count=excluded.element_count, bloom=excluded.bloom;`,
+		srcid, idx.Subset, updated, len(items), data)
+	return desc, err
+}
+
+// applyMapping adds any new pairs of identifiers to the mapping.
+func applyMapping(tx *sql.Tx, dir string, srcid int64, mp *manifestMapping, updated string) (string, error) {
+	if mp.To == "" {
+		return "", fmt.Errorf("mapping from %s without a 'to' source", mp.File)
+	}
+	leftCol, rightCol := mp.Left, mp.Right
+	if leftCol == "" {
+		leftCol = "1"
+	}
+	if rightCol == "" {
+		rightCol = "2"
+	}
+	attrs := pairAttributes(mp.Attributes)
+	if err := attrs.validate(); err != nil {
+		return "", err
+	}
+	leftID := srcid
+	rightID, err := getOrCreateSource(tx, mp.To)
+	if err != nil {
+		return "", err
+	}
+	swapped := 0
+	if rightID < leftID {
+		swapped = 1
+		leftID, rightID = rightID, leftID
+	}
+	table := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+	q1 := fmt.Sprintf("SELECT right_id FROM %s WHERE left_id=?;", table)
+	q2 := fmt.Sprintf("SELECT left_id FROM %s WHERE right_id=?;", table)
+	_, err = tx.Exec(`INSERT INTO source_mappings (left_source_id,right_source_id,mapfilename,last_update,
+		map_query_lr,map_query_rl) VALUES (?,?,?,?,?,?) ON CONFLICT DO NOTHING;`,
+		leftID, rightID, filepath.Base(mp.File), updated, q1, q2)
+	if err != nil {
+		return "", err
+	}
+	if err = createMappingTable(tx, table, attrs.names()); err != nil {
+		return "", err
+	}
+
+	// the reverse index is rebuilt after loading
+	_, err = tx.Exec(`DROP INDEX IF EXISTS ` + reverseIndex(leftID, rightID) + `;`)
+	if err != nil {
+		return "", err
+	}
+	loader, err := newPairLoader(tx, table, attrs.names())
+	if err != nil {
+		return "", err
+	}
+	attrVals := make([]interface{}, len(attrs.names()))
+	err = mp.each(dir, append([]string{leftCol, rightCol}, attrs.columns()...), 2, func(vals [][]string) error {
+		for i, v := range vals[2:] {
+			attrVals[i] = nil
+			if len(v) > 0 {
+				attrVals[i] = v[0]
+			}
+		}
+		for _, left := range vals[swapped] {
+			for _, right := range vals[1-swapped] {
+				if err := loader.Add(left, right, attrVals...); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	added, err := loader.Load()
+	if err != nil {
+		return "", err
+	}
+	if err = createReverseIndex(tx, "main", leftID, rightID); err != nil {
+		return "", err
+	}
+
+	desc := fmt.Sprintf("map to %s %s", mp.To, mp.File)
+	if added == 0 {
+		return desc + " unchanged", nil
+	}
+	_, err = tx.Exec(`UPDATE source_mappings SET last_update=?, element_count=(SELECT COUNT(*) FROM `+table+`)
+		WHERE left_source_id=? AND right_source_id=?;`, updated, leftID, rightID)
+	return desc + fmt.Sprintf(" = %d new pairs mapped", added), err
+}
+
+// each calls fn with the values of the selected columns for each record of
+// the table's files, which are read like the files of the index and map
+// commands (see table.each).
+func (t *manifestTable) each(dir string, columns []string, required int, fn func(vals [][]string) error) error {
+	if t.File == "" {
+		return fmt.Errorf("table without a file")
+	}
+	where := make(map[string]*regexp.Regexp, len(t.Where))
+	for sel, expr := range t.Where {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return err
+		}
+		where[sel] = re
+	}
+	for _, name := range append([]string{t.File}, t.Files...) {
+		if err := t.eachFile(filepath.Join(dir, name), where, columns, required, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *manifestTable) eachFile(filename string, where map[string]*regexp.Regexp, columns []string,
+	required int, fn func(vals [][]string) error) error {
+	tab, err := openTable(filename)
+	if err != nil {
+		return err
+	}
+	defer tab.Close()
+	tab.comment = t.Comment
+	tab.where = where
+	tab.trimPrefix = t.TrimPrefix
+	if t.NoHeader {
+		if err = tab.noHeader(); err != nil {
+			return err
+		}
+	}
+	_, err = tab.each(columns, required, fn)
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes each named file into a temporary directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// tableRows returns the values read from the table, with multiple values
+// joined by "|".
+func tableRows(t *testing.T, dir string, tab *manifestTable, columns []string, required int) ([]string, error) {
+	t.Helper()
+	var rows []string
+	err := tab.each(dir, columns, required, func(vals [][]string) error {
+		row := make([]string, len(vals))
+		for i, v := range vals {
+			row[i] = strings.Join(v, "|")
+		}
+		rows = append(rows, strings.Join(row, ","))
+		return nil
+	})
+	return rows, err
+}
+
+func TestManifestTableEach(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"mim2gene.txt": "# Copyright\n# MIM Number\tMIM Entry Type\tEntrez Gene ID\n" +
+			"100050\tphenotype\t\n100640\tgene\t216\n# comment\n100650\tgene\t217\n100660\tgene\t\n",
+		"kegg1.tsv": "hsa:1\tncbi-geneid:1\nhsa:2\tncbi-geneid:2\n",
+		"kegg2.tsv": "mmu:1\tncbi-geneid:11\n",
+		"genes.csv": "Gene list\nid,symbol,aliases\n1,A1BG,A1B|ABG\n2,A2M,\n3,NAT1,AAC1|MNAT|NAT-1\n",
+	})
+
+	for _, tc := range []struct {
+		name     string
+		tab      manifestTable
+		columns  []string
+		required int
+		want     []string
+	}{
+		{"comment where no header", manifestTable{File: "mim2gene.txt", NoHeader: true, Comment: "#",
+			Where: map[string]string{"2": "gene"}}, []string{"1", "3"}, 1,
+			[]string{"100640,216", "100650,217", "100660,"}},
+		{"trim prefix across files", manifestTable{File: "kegg1.tsv", Files: []string{"kegg2.tsv"}, NoHeader: true,
+			TrimPrefix: map[string]string{"2": "ncbi-geneid:"}}, []string{"2", "1"}, 2,
+			[]string{"1,hsa:1", "2,hsa:2", "11,mmu:1"}},
+		{"csv with multiple values", manifestTable{File: "genes.csv"}, []string{"symbol", "aliases"}, 2,
+			[]string{"A1BG,A1B|ABG", "NAT1,AAC1|MNAT|NAT-1"}},
+		{"where by name", manifestTable{File: "genes.csv", Where: map[string]string{"aliases": "MNAT"}},
+			[]string{"id"}, 1, []string{"3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tableRows(t, dir, &tc.tab, tc.columns, tc.required)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("rows = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		tab     manifestTable
+		columns []string
+	}{
+		{"column past the row width", manifestTable{File: "kegg1.tsv", NoHeader: true}, []string{"1", "3"}},
+		{"unknown column name", manifestTable{File: "genes.csv"}, []string{"name"}},
+		{"unknown where column", manifestTable{File: "genes.csv", Where: map[string]string{"9": "x"}}, []string{"1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tableRows(t, dir, &tc.tab, tc.columns, 1); err == nil {
+				t.Error("no error for a missing column")
+			}
+		})
+	}
+}
+
+func TestApplyManifest(t *testing.T) {
+	db := openTestDB(t)
+	dir := writeFiles(t, map[string]string{
+		"hgnc.csv": "HGNC ID,Approved symbol,NCBI Gene ID\nHGNC:5,A1BG,1\nHGNC:37133,A1BG-AS1,503538\nHGNC:24086,A1CF,\n",
+		"manifest.yaml": `sources:
+  - name: org.genenames.gene
+    description: HGNC Gene ID
+    indexes:
+      - file: hgnc.csv
+        column: HGNC ID
+        trim_prefix: {HGNC ID: "HGNC:"}
+    mappings:
+      - file: hgnc.csv
+        to: gov.nih.nlm.ncbi.gene
+        left: HGNC ID
+        right: NCBI Gene ID
+        trim_prefix: {1: "HGNC:"}
+`,
+	})
+
+	for run := 0; run < 2; run++ {
+		if err := applyManifest(db, filepath.Join(dir, "manifest.yaml"), "2024-01-02"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var items, pairs int
+	err := db.QueryRow(`SELECT element_count FROM source_indexes`).Scan(&items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow(`SELECT element_count FROM source_mappings`).Scan(&pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items != 3 || pairs != 2 {
+		t.Errorf("indexed %d items and mapped %d pairs, want 3 and 2", items, pairs)
+	}
+	var left string
+	if err = db.QueryRow(`SELECT left_id FROM mapping_1_to_2 WHERE right_id='503538'`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != "37133" {
+		t.Errorf("left_id = %q, want the trimmed HGNC ID", left)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// buildSelection chooses the sources, and optionally the subsets of each
+// source, copied into a build. A nil selection copies everything.
+type buildSelection map[string][]string
+
+// parseSelection parses "source" and "source:subset" arguments.
+func parseSelection(args []string) (buildSelection, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no sources selected (source or source:subset)")
+	}
+	sel := make(buildSelection)
+	for _, arg := range args {
+		parts := strings.SplitN(arg, ":", 2)
+		if _, ok := sel[parts[0]]; !ok {
+			sel[parts[0]] = nil
+		}
+		if len(parts) == 2 {
+			sel[parts[0]] = append(sel[parts[0]], parts[1])
+		}
+	}
+	return sel, nil
+}
+
+// source is true if the source is selected.
+func (s buildSelection) source(name string) bool {
+	if s == nil {
+		return true
+	}
+	_, ok := s[name]
+	return ok
+}
+
+// subset is true if the subset of a source is selected. All subsets of a
+// source are selected if none are named.
+func (s buildSelection) subset(name, subset string) bool {
+	if s == nil {
+		return true
+	}
+	subsets, ok := s[name]
+	if !ok {
+		return false
+	}
+	if len(subsets) == 0 {
+		return true
+	}
+	for _, x := range subsets {
+		if x == subset {
+			return true
+		}
+	}
+	return false
+}
+
+// positionalArgs returns the arguments after the command, parsing any
+// flags which follow them, e.g. "merge a.sqlite b.sqlite -o out.sqlite".
+func positionalArgs() []string {
+	var res []string
+	args := flag.Args()[1:]
+	for len(args) > 0 {
+		if strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+			if err := flag.CommandLine.Parse(args); err != nil {
+				log.Fatal(err)
+			}
+			args = flag.Args()
+			continue
+		}
+		res = append(res, args[0])
+		args = args[1:]
+	}
+	return res
+}
+
+// mergeDatabases creates a new build from the union of the sources,
+// subsets and mappings of the input builds. Source IDs are assigned anew,
+// so mapping tables are renamed to match. Metadata set in later inputs
+// replaces earlier values, and where an index or mapping is in more than
+// one input the most recently updated is kept. History and releases are
+// not copied.
+func mergeDatabases(outFilename string, inputs []string) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no databases to merge")
+	}
+	out, err := createBuild(outFilename)
+	if err != nil {
+		return err
+	}
+	for _, input := range inputs {
+		if err = copyBuild(out, input, nil); err != nil {
+			out.Close()
+			os.Remove(outFilename)
+			return fmt.Errorf("%s: %v", input, err)
+		}
+	}
+	return out.Close()
+}
+
+// subsetDatabase creates a new build with only the selected sources and
+// subsets of a build. Mappings are kept if both sources are selected.
+func subsetDatabase(inFilename, outFilename string, sel buildSelection) error {
+	out, err := createBuild(outFilename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err = copyBuild(out, inFilename, sel); err != nil {
+		out.Close()
+		os.Remove(outFilename)
+		return err
+	}
+	for name := range sel {
+		var n int
+		err = out.QueryRow(`SELECT COUNT(*) FROM sources WHERE name=?;`, name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			log.Printf("%s :: not found in %s", name, inFilename)
+		}
+	}
+	return nil
+}
+
+// createBuild creates and initializes a new source database.
+func createBuild(filename string) (*sql.DB, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("an output database is required (-o out.sqlite)")
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return nil, fmt.Errorf("%s already exists", filename)
+	}
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+	// the attached inputs are only visible to the one connection
+	db.SetMaxOpenConns(1)
+	if err = initDB(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// copyBuild copies the selected sources, indexes and mappings of a build
+// into out.
+func copyBuild(out *sql.DB, filename string, sel buildSelection) error {
+	if _, err := os.Stat(filename); err != nil {
+		return err
+	}
+	if _, err := out.Exec(`ATTACH DATABASE ? AS src;`, filename); err != nil {
+		return err
+	}
+	defer out.Exec(`DETACH DATABASE src;`)
+
+	tx, err := out.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ids, err := copySources(tx, sel)
+	if err != nil {
+		return err
+	}
+	if err = copyIndexes(tx, ids, sel); err != nil {
+		return err
+	}
+	if err = copyMappings(tx, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// copySources copies the metadata of the selected sources, returning the
+// new IDs of the sources by their IDs in the input.
+func copySources(tx *sql.Tx, sel buildSelection) (map[int64]int64, error) {
+	rows, err := tx.Query(`SELECT source_id,name,description,ident_type,url,id_url,citedata FROM src.sources;`)
+	if err != nil {
+		return nil, err
+	}
+	type source struct {
+		id     int64
+		name   string
+		fields [5]sql.NullString
+	}
+	var srcs []*source
+	for rows.Next() {
+		s := &source{}
+		f := &s.fields
+		if err = rows.Scan(&s.id, &s.name, &f[0], &f[1], &f[2], &f[3], &f[4]); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if sel.source(s.name) {
+			srcs = append(srcs, s)
+		}
+	}
+	rows.Close()
+
+	ids := make(map[int64]int64)
+	for _, s := range srcs {
+		newID, err := getOrCreateSource(tx, s.name)
+		if err != nil {
+			return nil, err
+		}
+		f := s.fields
+		_, err = tx.Exec(`UPDATE sources SET description=COALESCE(?,description),
+			ident_type=COALESCE(?,ident_type), url=COALESCE(?,url), id_url=COALESCE(?,id_url),
+			citedata=COALESCE(?,citedata) WHERE source_id=?;`, f[0], f[1], f[2], f[3], f[4], newID)
+		if err != nil {
+			return nil, err
+		}
+		ids[s.id] = newID
+	}
+
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM src.sqlite_master
+		WHERE type='table' AND name='source_deprecations';`).Scan(&n)
+	if err != nil || n == 0 {
+		return ids, err
+	}
+	for oldID, newID := range ids {
+		_, err = tx.Exec(`INSERT INTO main.source_deprecations (source_id,deprecated,reason)
+			SELECT ?,deprecated,reason FROM src.source_deprecations WHERE source_id=?
+			ON CONFLICT (source_id) DO UPDATE SET reason=excluded.reason;`, newID, oldID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// copyIndexes copies the selected indexes, unless a more recently updated
+// index of the subset has already been copied.
+func copyIndexes(tx *sql.Tx, ids map[int64]int64, sel buildSelection) error {
+	// NB the cast keeps the last_update text as it was written
+	rows, err := tx.Query(`SELECT s.source_id,s.name,i.subset,CAST(i.last_update AS TEXT),i.element_count,i.bloom
+		FROM src.sources s, src.source_indexes i WHERE s.source_id=i.source_id;`)
+	if err != nil {
+		return err
+	}
+	type index struct {
+		id           int64
+		name, subset string
+		updated      sql.NullString
+		count        sql.NullInt64
+		bloom        []byte
+	}
+	var idxs []*index
+	for rows.Next() {
+		x := &index{}
+		if err = rows.Scan(&x.id, &x.name, &x.subset, &x.updated, &x.count, &x.bloom); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := ids[x.id]; ok && sel.subset(x.name, x.subset) {
+			idxs = append(idxs, x)
+		}
+	}
+	rows.Close()
+
+	for _, x := range idxs {
+		res, err := tx.Exec(`INSERT INTO source_indexes (source_id,subset,last_update,element_count,bloom)
+			VALUES (?,?,?,?,?) ON CONFLICT (source_id,subset) DO UPDATE SET last_update=excluded.last_update,
+			element_count=excluded.element_count, bloom=excluded.bloom
+			WHERE source_indexes.last_update IS NULL OR excluded.last_update > source_indexes.last_update;`,
+			ids[x.id], x.subset, x.updated, x.count, x.bloom)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("%s[%s] :: kept the more recent index", x.name, x.subset)
+			continue
+		}
+		log.Printf("%s[%s] :: %d items copied", x.name, x.subset, x.count.Int64)
+	}
+	return nil
+}
+
+// copyMappings copies the mappings between the copied sources, unless a
+// more recently updated mapping has already been copied.
+func copyMappings(tx *sql.Tx, ids map[int64]int64) error {
+	rows, err := tx.Query(`SELECT l.name,r.name,m.left_source_id,m.right_source_id,m.mapfilename,
+		CAST(m.last_update AS TEXT) FROM src.source_mappings m, src.sources l, src.sources r
+		WHERE m.left_source_id=l.source_id AND m.right_source_id=r.source_id;`)
+	if err != nil {
+		return err
+	}
+	type mapping struct {
+		leftName, rightName string
+		leftID, rightID     int64
+		filename, updated   sql.NullString
+	}
+	var maps []*mapping
+	for rows.Next() {
+		m := &mapping{}
+		err = rows.Scan(&m.leftName, &m.rightName, &m.leftID, &m.rightID, &m.filename, &m.updated)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		_, lok := ids[m.leftID]
+		_, rok := ids[m.rightID]
+		if lok && rok {
+			maps = append(maps, m)
+		}
+	}
+	rows.Close()
+
+	for _, m := range maps {
+		leftID, rightID := ids[m.leftID], ids[m.rightID]
+		pairs := "left_id,right_id"
+		if rightID < leftID {
+			leftID, rightID = rightID, leftID
+			pairs = "right_id,left_id"
+		}
+		var current sql.NullString
+		err = tx.QueryRow(`SELECT CAST(last_update AS TEXT) FROM source_mappings
+			WHERE left_source_id=? AND right_source_id=?;`, leftID, rightID).Scan(&current)
+		if err == nil && current.Valid && current.String >= m.updated.String {
+			log.Printf("%s<>%s :: kept the more recent mapping", m.leftName, m.rightName)
+			continue
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		// NB unqualified names could resolve to the attached input
+		table := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+		_, err = tx.Exec(`DROP TABLE IF EXISTS main.` + table + `;`)
+		if err != nil {
+			return err
+		}
+		srcTable := fmt.Sprintf("mapping_%d_to_%d", m.leftID, m.rightID)
+		srcAttrs, err := tableAttributes(tx, "src", srcTable)
+		if err != nil {
+			return err
+		}
+		var attrs []string
+		for _, attr := range sources.PairAttributes {
+			if srcAttrs[attr] {
+				attrs = append(attrs, attr)
+				pairs += "," + attr
+			}
+		}
+		// the table was dropped, so it's created in main
+		if err = createMappingTable(tx, table, attrs); err != nil {
+			return err
+		}
+		cols := strings.Join(append([]string{"left_id", "right_id"}, attrs...), ",")
+		res, err := tx.Exec(fmt.Sprintf(`INSERT INTO main.%s (%s) SELECT %s FROM src.%s;`,
+			table, cols, pairs, srcTable))
+		if err != nil {
+			return err
+		}
+		count, _ := res.RowsAffected()
+		q1 := fmt.Sprintf("SELECT right_id FROM %s WHERE left_id=?;", table)
+		q2 := fmt.Sprintf("SELECT left_id FROM %s WHERE right_id=?;", table)
+		_, err = tx.Exec(`INSERT INTO source_mappings (left_source_id,right_source_id,mapfilename,last_update,
+			map_query_lr,map_query_rl,element_count) VALUES (?,?,?,?,?,?,?) ON CONFLICT (left_source_id,right_source_id)
+			DO UPDATE SET mapfilename=excluded.mapfilename, last_update=excluded.last_update,
+			element_count=excluded.element_count;`,
+			leftID, rightID, m.filename, m.updated, q1, q2, count)
+		if err != nil {
+			return err
+		}
+		if err = createReverseIndex(tx, "main", leftID, rightID); err != nil {
+			return err
+		}
+		log.Printf("%s<>%s :: %d pairs copied", m.leftName, m.rightName, count)
+	}
+	return nil
+}
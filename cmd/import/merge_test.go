@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeDatabases(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"old.csv":     "gene,protein\n1,P1\n2,P2\n",
+		"new.csv":     "gene,protein\n1,P1\n3,P3\n",
+		"ensembl.csv": "gene,ensembl\n1,ENSG01\n3,ENSG03\n",
+	})
+	build := func(name string, steps func(db *sql.DB)) string {
+		t.Helper()
+		filename := filepath.Join(dir, name)
+		db, err := createBuild(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		steps(db)
+		return filename
+	}
+	mapping := func(db *sql.DB, left, right, file, leftColumn, rightColumn, updated string) {
+		t.Helper()
+		err := updateMapping(db, left, right, filepath.Join(dir, file), leftColumn, rightColumn, nil, updated)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// gene=1, protein=2
+	a := build("a.sqlite", func(db *sql.DB) {
+		mapping(db, "gov.nih.nlm.ncbi.gene", "org.uniprot.acc", "old.csv", "gene", "protein", "2024-01-01")
+	})
+	// protein=1, gene=2, ensembl=3, so the newer mapping is stored reversed
+	b := build("b.sqlite", func(db *sql.DB) {
+		if _, err := getOrCreateSource(db, "org.uniprot.acc"); err != nil {
+			t.Fatal(err)
+		}
+		mapping(db, "gov.nih.nlm.ncbi.gene", "org.uniprot.acc", "new.csv", "gene", "protein", "2024-02-01")
+		mapping(db, "gov.nih.nlm.ncbi.gene", "org.ensembl.gene", "ensembl.csv", "gene", "ensembl", "2024-02-01")
+	})
+
+	out := filepath.Join(dir, "out.sqlite")
+	if err := mergeDatabases(out, []string{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids := make(map[string]int64)
+	for _, name := range []string{"gov.nih.nlm.ncbi.gene", "org.uniprot.acc", "org.ensembl.gene"} {
+		var id int64
+		if err = db.QueryRow(`SELECT source_id FROM sources WHERE name=?;`, name).Scan(&id); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ids[name] = id
+	}
+	if want := map[string]int64{"gov.nih.nlm.ncbi.gene": 1, "org.uniprot.acc": 2, "org.ensembl.gene": 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("source IDs = %v, want %v", ids, want)
+	}
+	if got, want := mappedPairs(t, db, "mapping_1_to_2"), []string{"1:P1", "3:P3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gene<>protein pairs = %q, want the newer mapping with the columns swapped back %q", got, want)
+	}
+	if got, want := mappedPairs(t, db, "mapping_1_to_3"), []string{"1:ENSG01", "3:ENSG03"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gene<>ensembl pairs = %q, want %q", got, want)
+	}
+
+	var mappings int
+	err = db.QueryRow(`SELECT COUNT(*) FROM source_mappings WHERE (left_source_id=1 AND right_source_id IN (2,3))
+		AND last_update='2024-02-01' AND element_count=2;`).Scan(&mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mappings != 2 {
+		t.Errorf("%d mappings with the newer metadata, want 2", mappings)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// release IDs are used as filenames of the snapshots
+var releaseIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// createReleases creates the tables recording named releases, if they
+// don't exist in an older database.
+func createReleases(db dbtx) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS releases (
+				release_id varchar primary key,
+				created datetime,
+				description varchar
+			);`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS release_sources (
+				release_id varchar,
+				source_id integer,
+				last_update datetime,
+				checksum varchar,
+				primary key (release_id, source_id)
+			);`)
+	return err
+}
+
+// createRelease records a named release with a checksum of each source,
+// and writes a snapshot of the database into dir which can be loaded
+// alongside other releases (see sources.OpenReleases).
+func createRelease(db *sql.DB, dir, releaseID, description string) error {
+	if !releaseIDPattern.MatchString(releaseID) {
+		return fmt.Errorf("invalid release ID %q (letters, digits, '.', '_' and '-' only)", releaseID)
+	}
+	snapshot := sources.ReleaseSnapshotPath(dir, releaseID)
+	if _, err := os.Stat(snapshot); err == nil {
+		return fmt.Errorf("release snapshot %s already exists", snapshot)
+	}
+	if err := createReleases(db); err != nil {
+		return err
+	}
+	sums, err := sourceChecksums(db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO releases (release_id,created,description) VALUES (?,?,?);`,
+		releaseID, time.Now().UTC().Format("2006-01-02T15:04:05"), description)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("release %s: %v", releaseID, err)
+	}
+	for srcid, sum := range sums {
+		_, err = tx.Exec(`INSERT INTO release_sources (release_id,source_id,last_update,checksum)
+			SELECT ?, ?, MAX(last_update), ? FROM source_indexes WHERE source_id=?;`,
+			releaseID, srcid, sum, srcid)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if err = writeSnapshot(db, snapshot, releaseID); err != nil {
+		db.Exec(`DELETE FROM release_sources WHERE release_id=?;`, releaseID)
+		db.Exec(`DELETE FROM releases WHERE release_id=?;`, releaseID)
+		os.Remove(snapshot)
+		return err
+	}
+	fmt.Println(releaseID, "=", len(sums), "sources", snapshot)
+	return nil
+}
+
+// writeSnapshot copies the database to a new file marked as the release.
+func writeSnapshot(db *sql.DB, snapshot, releaseID string) error {
+	if err := os.MkdirAll(filepath.Dir(snapshot), 0755); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`VACUUM INTO ?;`, snapshot); err != nil {
+		return err
+	}
+	sdb, err := sql.Open("sqlite3", snapshot)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+	_, err = sdb.Exec(`CREATE TABLE release_snapshot (release_id varchar);`)
+	if err == nil {
+		_, err = sdb.Exec(`INSERT INTO release_snapshot (release_id) VALUES (?);`, releaseID)
+	}
+	return err
+}
+
+// listReleases prints the releases recorded in the database, and checks
+// that their snapshots in dir load side by side with matching checksums.
+func listReleases(db *sql.DB, dir string) error {
+	if err := createReleases(db); err != nil {
+		return err
+	}
+	loaded, err := sources.OpenReleases(dir, nil)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT r.release_id, r.created, r.description, COUNT(s.source_id)
+		FROM releases r LEFT JOIN release_sources s ON r.release_id=s.release_id
+		GROUP BY r.release_id ORDER BY r.created;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var created time.Time
+		var desc sql.NullString
+		var n int
+		if err = rows.Scan(&id, &created, &desc, &n); err != nil {
+			return err
+		}
+		status := "snapshot missing"
+		if _, ok := loaded[id]; ok {
+			status, err = verifySnapshot(sources.ReleaseSnapshotPath(dir, id), id)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%s\t%s\t%d sources\t%s\t%s\n", id, created.Format("2006-01-02 15:04:05"), n, status, desc.String)
+	}
+	return rows.Err()
+}
+
+// verifySnapshot compares the checksums of the data in a release snapshot
+// to those recorded when it was created.
+func verifySnapshot(snapshot, releaseID string) (string, error) {
+	sdb, err := sql.Open("sqlite3", snapshot)
+	if err != nil {
+		return "", err
+	}
+	defer sdb.Close()
+	sums, err := sourceChecksums(sdb)
+	if err != nil {
+		return "", err
+	}
+	rows, err := sdb.Query(`SELECT source_id,checksum FROM release_sources WHERE release_id=?;`, releaseID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var id int64
+		var sum string
+		if err = rows.Scan(&id, &sum); err != nil {
+			return "", err
+		}
+		if sums[id] != sum {
+			return "snapshot modified", nil
+		}
+		n++
+	}
+	if n != len(sums) {
+		return "snapshot modified", rows.Err()
+	}
+	return "snapshot ok", rows.Err()
+}
+
+// sourceChecksums returns a checksum of the metadata, indexes and mappings
+// of each source, by source ID.
+func sourceChecksums(db *sql.DB) (map[int64]string, error) {
+	hashes := make(map[int64]hash.Hash)
+	rows, err := db.Query(`SELECT source_id,name,description,ident_type,url,id_url,citedata
+		FROM sources ORDER BY source_id;`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var fields [6]sql.NullString
+		err = rows.Scan(&id, &fields[0], &fields[1], &fields[2], &fields[3], &fields[4], &fields[5])
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		h := sha256.New()
+		for _, f := range fields {
+			fmt.Fprintf(h, "%q\n", f.String)
+		}
+		hashes[id] = h
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT source_id,subset,bloom FROM source_indexes ORDER BY source_id,subset;`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var subset string
+		var bloom []byte
+		if err = rows.Scan(&id, &subset, &bloom); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if h, ok := hashes[id]; ok {
+			fmt.Fprintf(h, "index %q %x\n", subset, sha256.Sum256(bloom))
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT left_source_id,right_source_id FROM source_mappings
+		ORDER BY left_source_id,right_source_id;`)
+	if err != nil {
+		return nil, err
+	}
+	var pairs [][2]int64
+	for rows.Next() {
+		var p [2]int64
+		if err = rows.Scan(&p[0], &p[1]); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pairs = append(pairs, p)
+	}
+	rows.Close()
+	for _, p := range pairs {
+		sum, err := mappingChecksum(db, p[0], p[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range p {
+			if h, ok := hashes[id]; ok {
+				fmt.Fprintf(h, "mapping %d %d %s\n", p[0], p[1], sum)
+			}
+		}
+	}
+
+	sums := make(map[int64]string, len(hashes))
+	for id, h := range hashes {
+		sums[id] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// mappingChecksum returns a checksum of the pairs in a mapping table.
+func mappingChecksum(db *sql.DB, leftID, rightID int64) (string, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT left_id,right_id FROM mapping_%d_to_%d
+		ORDER BY left_id,right_id;`, leftID, rightID))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	h := sha256.New()
+	for rows.Next() {
+		var left, right string
+		if err = rows.Scan(&left, &right); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\t%s\n", left, right)
+	}
+	return hex.EncodeToString(h.Sum(nil)), rows.Err()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// createDeprecations creates the table of deprecated sources, if it
+// doesn't exist in an older database.
+func createDeprecations(db dbtx) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS source_deprecations (
+				source_id integer primary key,
+				deprecated datetime,
+				reason varchar
+			);`)
+	return err
+}
+
+// getSource returns the ID of an existing source.
+func getSource(db dbtx, sourceName string) (int64, error) {
+	var sid int64
+	err := db.QueryRow("SELECT source_id FROM sources WHERE name=?;", sourceName).Scan(&sid)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("unknown source %q", sourceName)
+	}
+	return sid, err
+}
+
+// deprecateSource marks a source as deprecated, so that it is no longer
+// detected, or restores it. Mappings to and from the source still work, so
+// that earlier translations can be reproduced.
+func deprecateSource(db *sql.DB, sourceName, reason string, deprecated bool) error {
+	srcid, err := getSource(db, sourceName)
+	if err != nil {
+		return err
+	}
+	if err = createDeprecations(db); err != nil {
+		return err
+	}
+	if !deprecated {
+		_, err = db.Exec(`DELETE FROM source_deprecations WHERE source_id=?;`, srcid)
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO source_deprecations (source_id,deprecated,reason) VALUES (?,?,?)
+		ON CONFLICT (source_id) DO UPDATE SET reason=excluded.reason;`,
+		srcid, time.Now().UTC().Format("2006-01-02T15:04:05"), reason)
+	return err
+}
+
+// removeSource deletes a source with its indexes, mappings and history.
+// Releases which included the source are kept, as their snapshots are
+// unaffected.
+func removeSource(db *sql.DB, sourceName string) error {
+	srcid, err := getSource(db, sourceName)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = createHistory(tx); err != nil {
+		return err
+	}
+	if err = createDeprecations(tx); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT left_source_id,right_source_id FROM source_mappings
+		WHERE left_source_id=? OR right_source_id=?;`, srcid, srcid)
+	if err != nil {
+		return err
+	}
+	var pairs [][2]int64
+	for rows.Next() {
+		var p [2]int64
+		if err = rows.Scan(&p[0], &p[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		pairs = append(pairs, p)
+	}
+	rows.Close()
+	for _, p := range pairs {
+		if err = dropMapping(tx, p[0], p[1]); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range []string{
+		`DELETE FROM source_index_history WHERE source_id=?;`,
+		`DELETE FROM source_indexes WHERE source_id=?;`,
+		`DELETE FROM source_deprecations WHERE source_id=?;`,
+		`DELETE FROM sources WHERE source_id=?;`,
+	} {
+		if _, err = tx.Exec(q, srcid); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s :: removed with %d mappings", sourceName, len(pairs))
+	return tx.Commit()
+}
+
+// removeSubset deletes the index of a subset of a source, and its history.
+func removeSubset(db *sql.DB, sourceName, subsetName string) error {
+	srcid, err := getSource(db, sourceName)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = createHistory(tx); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM source_indexes WHERE source_id=? AND subset=?;`, srcid, subsetName)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s has no subset %q", sourceName, subsetName)
+	}
+	_, err = tx.Exec(`DELETE FROM source_index_history WHERE source_id=? AND subset=?;`, srcid, subsetName)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s[%s] :: removed", sourceName, subsetName)
+	return tx.Commit()
+}
+
+// removeMapping deletes a mapping between two sources, its table and
+// its history.
+func removeMapping(db *sql.DB, leftSourceName, rightSourceName string) error {
+	leftID, err := getSource(db, leftSourceName)
+	if err != nil {
+		return err
+	}
+	rightID, err := getSource(db, rightSourceName)
+	if err != nil {
+		return err
+	}
+	if rightID < leftID {
+		leftID, rightID = rightID, leftID
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = createHistory(tx); err != nil {
+		return err
+	}
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM source_mappings WHERE left_source_id=? AND right_source_id=?;`,
+		leftID, rightID).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no mapping between %s and %s", leftSourceName, rightSourceName)
+	}
+	if err = dropMapping(tx, leftID, rightID); err != nil {
+		return err
+	}
+	log.Printf("%s<>%s :: removed", leftSourceName, rightSourceName)
+	return tx.Commit()
+}
+
+// dropMapping deletes a mapping table and the rows describing it.
+func dropMapping(tx *sql.Tx, leftID, rightID int64) error {
+	_, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS mapping_%d_to_%d;`, leftID, rightID))
+	if err != nil {
+		return err
+	}
+	for _, q := range []string{
+		`DELETE FROM source_mapping_history WHERE left_source_id=? AND right_source_id=?;`,
+		`DELETE FROM source_mappings WHERE left_source_id=? AND right_source_id=?;`,
+	} {
+		if _, err = tx.Exec(q, leftID, rightID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joiningdata/databio/formats"
+)
+
+// table reads records from a data file using the formats readers.
+type table struct {
+	filename string
+
+	f   *os.File
+	tmp string
+	r   formats.Reader
+
+	// the header row was set and is not detected
+	fixedHeader bool
+
+	// comment is a prefix of the first column of records to skip
+	comment string
+	// where skips records unless a value of each column fully matches
+	where map[string]*regexp.Regexp
+	// trimPrefix removes a prefix from the values of each column
+	trimPrefix map[string]string
+}
+
+// openTable opens a data file, decompressing it first if the filename ends
+// in ".gz". Files without a supported extension are read as tab-delimited
+// text, with header detection as usual.
+func openTable(filename string) (*table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{filename: filename, f: f}
+	if ext := filepath.Ext(filename); strings.EqualFold(ext, ".gz") {
+		err = t.decompress(filepath.Ext(strings.TrimSuffix(filename, ext)))
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+
+	t.r, err = formats.Open(t.f)
+	if err == formats.ErrUnsupportedFormat {
+		t.r, err = formats.OpenTSV(t.f)
+	}
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// decompress replaces the gzipped input with a temporary file, as the
+// formats readers need to seek.
+func (t *table) decompress(ext string) error {
+	src := t.f
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tmp, err := ioutil.TempFile("", "databio-import-*"+ext)
+	if err != nil {
+		return err
+	}
+	t.f, t.tmp = tmp, tmp.Name()
+	if _, err = io.Copy(tmp, zr); err != nil {
+		return err
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	return err
+}
+
+// noHeader reads the first row as a record instead of column names, which
+// are generated (see formats.HeaderReader).
+func (t *table) noHeader() error {
+	hr, ok := t.r.(formats.HeaderReader)
+	if !ok {
+		return nil
+	}
+	t.fixedHeader = true
+	return hr.SetHeaderRow(-1)
+}
+
+// Close closes the file and removes any temporary file.
+func (t *table) Close() error {
+	if c, ok := t.r.(io.Closer); ok {
+		c.Close()
+	}
+	err := t.f.Close()
+	if t.tmp != "" {
+		if rerr := os.Remove(t.tmp); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// each calls fn with the values of the selected columns for each record,
+// skipping records with a blank value in any of the first required columns.
+// Columns are selected by field name or by 1-based number, as with cut -f.
+// Records that are missing a column are logged as malformed and skipped,
+// records filtered by the comment and where options are skipped silently.
+// Returns the number of malformed records.
+func (t *table) each(selectors []string, required int, fn func(vals [][]string) error) (int, error) {
+	rec, err := t.r.Next()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	fields, err := t.columns(rec.Fields(), selectors)
+	if hr, ok := t.r.(formats.HeaderReader); ok && err != nil && !t.fixedHeader && hr.HeaderRow() != 0 {
+		// the column names may be in a row that wasn't detected as the header
+		if err = hr.SetHeaderRow(0); err != nil {
+			return 0, err
+		}
+		if rec, err = t.r.Next(); err != nil {
+			return 0, err
+		}
+		fields, err = t.columns(rec.Fields(), selectors)
+	}
+	if err != nil {
+		return 0, err
+	}
+	where, err := t.whereFields(rec.Fields())
+	if err != nil {
+		return 0, err
+	}
+	trim := make(map[string]string, len(t.trimPrefix))
+	for sel, prefix := range t.trimPrefix {
+		field, err := t.columns(rec.Fields(), []string{sel})
+		if err != nil {
+			return 0, err
+		}
+		trim[field[0]] = prefix
+	}
+
+	malformed := 0
+	vals := make([][]string, len(fields))
+nextRecord:
+	for n := 1; err == nil; rec, err = t.r.Next() {
+		if t.skip(rec, where) {
+			n++
+			continue
+		}
+		for i, field := range fields {
+			raw := rec.Values(field)
+			if raw == nil {
+				malformed++
+				if malformed <= 10 {
+					log.Printf("%s: record %d is missing column %q", t.filename, n, field)
+				}
+				n++
+				continue nextRecord
+			}
+			vals[i] = vals[i][:0]
+			for _, v := range raw {
+				if v = strings.TrimSpace(strings.TrimPrefix(v, trim[field])); v != "" {
+					vals[i] = append(vals[i], v)
+				}
+			}
+		}
+		n++
+		for _, v := range vals[:required] {
+			if len(v) == 0 {
+				// skip records with a blank value
+				continue nextRecord
+			}
+		}
+		if err = fn(vals); err != nil {
+			return malformed, err
+		}
+	}
+	if malformed > 10 {
+		log.Printf("%s: %d malformed records skipped", t.filename, malformed)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return malformed, err
+}
+
+// whereFields returns the expression for each field name of the where option.
+func (t *table) whereFields(fields []string) (map[string]*regexp.Regexp, error) {
+	where := make(map[string]*regexp.Regexp, len(t.where))
+	for sel, re := range t.where {
+		field, err := t.columns(fields, []string{sel})
+		if err != nil {
+			return nil, err
+		}
+		where[field[0]] = re
+	}
+	return where, nil
+}
+
+// skip is true for comment records, and records where a column doesn't
+// match its expression. Blank columns are matched as an empty string.
+func (t *table) skip(rec formats.Record, where map[string]*regexp.Regexp) bool {
+	if fields := rec.Fields(); t.comment != "" && len(fields) > 0 {
+		if vals := rec.Values(fields[0]); len(vals) > 0 && strings.HasPrefix(vals[0], t.comment) {
+			return true
+		}
+	}
+nextField:
+	for field, re := range where {
+		vals := rec.Values(field)
+		if len(vals) == 0 {
+			vals = []string{""}
+		}
+		for _, v := range vals {
+			if re.MatchString(v) {
+				continue nextField
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// columns returns the field name for each column selector.
+func (t *table) columns(fields []string, selectors []string) ([]string, error) {
+	res := make([]string, len(selectors))
+nextSelector:
+	for i, sel := range selectors {
+		for _, f := range fields {
+			if f == sel {
+				res[i] = f
+				continue nextSelector
+			}
+		}
+		n, err := strconv.Atoi(sel)
+		if err != nil || n < 1 || n > len(fields) {
+			return nil, fmt.Errorf("column %q not found in %s", sel, t.filename)
+		}
+		res[i] = fields[n-1]
+	}
+	return res, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/joiningdata/databio/sources"
+)
+
+// createHistory creates the tables recording the previous releases of
+// indexes and mappings, if they don't exist in an older database.
+func createHistory(db dbtx) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS source_index_history (
+				source_id integer,
+				subset varchar,
+				last_update datetime,
+				element_count integer,
+				replaced datetime -- last_update of the replacement
+			);`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS source_mapping_history (
+				left_source_id integer,
+				right_source_id integer,
+				mapfilename varchar,
+				last_update datetime,
+				element_count integer,
+				replaced datetime -- last_update of the replacement
+			);`)
+	return err
+}
+
+// updateIndex rebuilds the index of a subset, replacing any previous
+// release. The added and removed counts are estimated from the previous
+// Bloom filter, as the identifiers themselves aren't stored.
+func updateIndex(db *sql.DB, sourceName, subsetName, filename, column, updated string) error {
+	srcid, err := getOrCreateSource(db, sourceName)
+	if err != nil {
+		return err
+	}
+	items, err := readIdentifiers(filename, column)
+	if err != nil {
+		return err
+	}
+	data := packIdentifiers(items)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = createHistory(tx); err != nil {
+		return err
+	}
+
+	var oldData []byte
+	var oldCount sql.NullInt64
+	err = tx.QueryRow(`SELECT bloom,element_count FROM source_indexes
+		WHERE source_id=? AND subset=?;`, srcid, subsetName).Scan(&oldData, &oldCount)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(`INSERT INTO source_indexes (source_id,subset,last_update,element_count,bloom)
+			VALUES (?,?,?,?,?);`, srcid, subsetName, updated, len(items), data)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s[%s] :: %s = %d items indexed", sourceName, subsetName, filename, len(items))
+		return tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	old := &sources.BloomFilter{}
+	if err = old.Unpack(oldData); err != nil {
+		return err
+	}
+	added := 0
+	for x := range items {
+		if found, _ := old.Detect(x); !found {
+			added++
+		}
+	}
+	removed := int(oldCount.Int64) - (len(items) - added)
+	if removed < 0 {
+		removed = 0
+	}
+
+	_, err = tx.Exec(`INSERT INTO source_index_history (source_id,subset,last_update,element_count,replaced)
+		SELECT source_id,subset,last_update,element_count,? FROM source_indexes
+		WHERE source_id=? AND subset=?;`, updated, srcid, subsetName)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE source_indexes SET last_update=?, element_count=?, bloom=?
+		WHERE source_id=? AND subset=?;`, updated, len(items), data, srcid, subsetName)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s[%s] :: %s = %d items re-indexed (about %d added, %d removed)",
+		sourceName, subsetName, filename, len(items), added, removed)
+	return tx.Commit()
+}
+
+// updateMapping replaces the pairs of a mapping. The new pairs are loaded
+// into a shadow table which is swapped in, so that the mapping is replaced
+// atomically.
+func updateMapping(db *sql.DB, leftSourceName, rightSourceName, filename, leftColumn, rightColumn string,
+	attrs pairAttributes, updated string) error {
+	if err := attrs.validate(); err != nil {
+		return err
+	}
+	leftID, err := getOrCreateSource(db, leftSourceName)
+	if err != nil {
+		return err
+	}
+	rightID, err := getOrCreateSource(db, rightSourceName)
+	if err != nil {
+		return err
+	}
+	swapped := 0
+	if rightID < leftID {
+		swapped = 1
+		leftID, rightID = rightID, leftID
+	}
+	table := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+	shadow := table + "_update"
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = createHistory(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP TABLE IF EXISTS ` + shadow + `;`)
+	if err != nil {
+		return err
+	}
+	if err = createMappingTable(tx, table, nil); err != nil {
+		return err
+	}
+	if err = createMappingTable(tx, shadow, attrs.names()); err != nil {
+		return err
+	}
+
+	loader, err := newPairLoader(tx, shadow, attrs.names())
+	if err != nil {
+		return err
+	}
+	_, malformed, err := insertPairs(loader, filename, leftColumn, rightColumn, attrs, swapped)
+	if err != nil {
+		return err
+	}
+	if _, err = loader.Load(); err != nil {
+		return err
+	}
+
+	var count, added, removed int
+	err = tx.QueryRow(fmt.Sprintf(`SELECT (SELECT COUNT(*) FROM %[1]s),
+		(SELECT COUNT(*) FROM (SELECT left_id,right_id FROM %[1]s EXCEPT SELECT left_id,right_id FROM %[2]s)),
+		(SELECT COUNT(*) FROM (SELECT left_id,right_id FROM %[2]s EXCEPT SELECT left_id,right_id FROM %[1]s));`,
+		shadow, table)).Scan(&count, &added, &removed)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO source_mapping_history (left_source_id,right_source_id,mapfilename,
+		last_update,element_count,replaced) SELECT left_source_id,right_source_id,mapfilename,last_update,
+		element_count,? FROM source_mappings WHERE left_source_id=? AND right_source_id=?;`,
+		updated, leftID, rightID)
+	if err != nil {
+		return err
+	}
+	q1 := fmt.Sprintf("SELECT right_id FROM %s WHERE left_id=?;", table)
+	q2 := fmt.Sprintf("SELECT left_id FROM %s WHERE right_id=?;", table)
+	_, err = tx.Exec(`INSERT INTO source_mappings (left_source_id,right_source_id,mapfilename,last_update,
+		map_query_lr,map_query_rl,element_count) VALUES (?,?,?,?,?,?,?) ON CONFLICT (left_source_id,right_source_id)
+		DO UPDATE SET mapfilename=excluded.mapfilename, last_update=excluded.last_update,
+		element_count=excluded.element_count;`,
+		leftID, rightID, filepath.Base(filename), updated, q1, q2, count)
+	if err != nil {
+		return err
+	}
+
+	// swap in the shadow table
+	_, err = tx.Exec(`DROP TABLE ` + table + `;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE ` + shadow + ` RENAME TO ` + table + `;`)
+	if err != nil {
+		return err
+	}
+	if err = createReverseIndex(tx, "main", leftID, rightID); err != nil {
+		return err
+	}
+	log.Printf("%s<>%s :: %s = %d pairs mapped (%d added, %d removed, %d malformed rows)",
+		leftSourceName, rightSourceName, filename, count, added, removed, malformed)
+	return tx.Commit()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mappedPairs returns the "left:right" pairs of a mapping table in order.
+func mappedPairs(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT left_id,right_id FROM ` + table + ` ORDER BY left_id,right_id;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var pairs []string
+	for rows.Next() {
+		var left, right string
+		if err = rows.Scan(&left, &right); err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, left+":"+right)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return pairs
+}
+
+func TestUpdateMapping(t *testing.T) {
+	db := openTestDB(t)
+	// the right source is created first, so the pairs are swapped
+	if _, err := getOrCreateSource(db, "org.uniprot.acc"); err != nil {
+		t.Fatal(err)
+	}
+	dir := writeFiles(t, map[string]string{
+		"v1.csv": "gene,protein\n1,P1\n2,P2\n3,P3\n",
+		"v2.csv": "gene,protein\n1,P1\n2,P2\n4,P4\n5,P5\n",
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range []struct {
+		file, updated, counts string
+		pairs                 []string
+	}{
+		{"v1.csv", "2024-01-01", "3 pairs mapped (3 added, 0 removed, 0 malformed rows)",
+			[]string{"P1:1", "P2:2", "P3:3"}},
+		{"v2.csv", "2024-02-01", "4 pairs mapped (2 added, 1 removed, 0 malformed rows)",
+			[]string{"P1:1", "P2:2", "P4:4", "P5:5"}},
+	} {
+		logged.Reset()
+		err := updateMapping(db, "gov.nih.nlm.ncbi.gene", "org.uniprot.acc", filepath.Join(dir, tc.file),
+			"gene", "protein", nil, tc.updated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(logged.String(), tc.counts) {
+			t.Errorf("%s logged %q, want %q", tc.file, logged.String(), tc.counts)
+		}
+		if got := mappedPairs(t, db, "mapping_1_to_2"); !reflect.DeepEqual(got, tc.pairs) {
+			t.Errorf("%s mapped %q, want %q", tc.file, got, tc.pairs)
+		}
+	}
+
+	var count int
+	var lastUpdate, replaced string
+	err := db.QueryRow(`SELECT element_count,CAST(last_update AS TEXT),CAST(replaced AS TEXT)
+		FROM source_mapping_history;`).Scan(&count, &lastUpdate, &replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || lastUpdate != "2024-01-01" || replaced != "2024-02-01" {
+		t.Errorf("history = %d pairs updated %s replaced %s, want the first release", count, lastUpdate, replaced)
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/joiningdata/databio"
@@ -31,6 +33,9 @@ const (
 	databioSessionName = "databio-session"
 	maxUploadBytes     = 32 << 20 // 32MB
 
+	// uploads beyond maxUploadBytes are streamed from disk, up to this limit
+	maxUploadFileBytes = 1 << 30 // 1GB
+
 	uploadBase = "uploads"
 )
 
@@ -39,6 +44,7 @@ var (
 	templates *template.Template
 
 	srcDB    *sources.Database
+	releases sources.Releases
 	detector *detection.Detector
 	mapper   *mapping.Mapper
 )
@@ -55,11 +61,14 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// uploads larger than maxUploadBytes are stored in temporary files
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadFileBytes)
 	err := r.ParseMultipartForm(maxUploadBytes)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
 	fhs, ok := r.MultipartForm.File["data"]
 	if !ok {
 		http.Error(w, "upload missing", http.StatusBadRequest)
@@ -131,6 +140,27 @@ func reportHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("report.html", templates.ExecuteTemplate(w, "report.html", ctx))
 }
 
+func headerHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := store.Get(r, databioSessionName)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fname, ok := session.Values["documentKey"].(string)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	row, err := strconv.Atoi(r.URL.Query().Get("row"))
+	if err != nil || row < -1 {
+		http.Error(w, "invalid header row", http.StatusBadRequest)
+		return
+	}
+
+	token := detector.StartWithHeader(fname, row)
+	http.Redirect(w, r, "/report?k="+token, http.StatusSeeOther)
+}
+
 func quickmapHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := store.Get(r, databioSessionName)
 	if err != nil {
@@ -142,20 +172,29 @@ func quickmapHandler(w http.ResponseWriter, r *http.Request) {
 	toID := r.Form.Get("to")
 	idlist := strings.Split(strings.TrimSpace(r.Form.Get("ids")), "\n")
 
-	translator, err := srcDB.GetMapper(fromID, toID)
+	filter, err := mappingFilter(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	translator, err := srcDB.GetFilteredMapper(fromID, toID, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	for i, id := range idlist {
+		idlist[i] = strings.TrimSpace(id)
+	}
+	mapped := translator.GetMany(idlist)
+
 	dest := srcDB.Sources[toID]
 	for _, id := range idlist {
-		id = strings.TrimSpace(id)
 		if id == "" {
 			//fmt.Fprintln(w)
 			continue
 		}
-		id2s, ok := translator.Get(id)
+		id2s, ok := mapped[id]
 		if !ok || len(id2s) == 0 {
 			fmt.Fprintf(w, "(missing %s='%s')\n", fromID, id)
 		} else {
@@ -170,6 +209,27 @@ func quickmapHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// mappingFilter parses the optional relationship, evidence, taxon and
+// max_rank parameters which restrict a mapping.
+func mappingFilter(q url.Values) (*sources.MappingFilter, error) {
+	f := &sources.MappingFilter{
+		Relationship: q.Get("relationship"),
+		Evidence:     q.Get("evidence"),
+		Taxon:        strings.TrimSpace(q.Get("taxon")),
+	}
+	if r := q.Get("max_rank"); r != "" {
+		n, err := strconv.Atoi(r)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid max_rank")
+		}
+		f.MaxRank = n
+	}
+	if f.IsZero() {
+		return nil, nil
+	}
+	return f, nil
+}
+
 func translateHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := store.Get(r, databioSessionName)
 	if err != nil {
@@ -188,6 +248,37 @@ func translateHandler(w http.ResponseWriter, r *http.Request) {
 	fromField := string(fb)
 	fromID := q.Get("from")
 	toID := q.Get("to")
+	outFormat := q.Get("format")
+	if outFormat == "" {
+		outFormat = "csv"
+	}
+
+	var delims map[string]string
+	if d, ok := q["delim"]; ok {
+		// override the detected multi-value delimiter
+		delims = map[string]string{fromField: d[0]}
+	}
+
+	var headerRow *int
+	if h, err := strconv.Atoi(q.Get("header")); err == nil {
+		if h < -1 {
+			http.Error(w, "invalid header row", http.StatusBadRequest)
+			return
+		}
+		headerRow = &h
+	}
+
+	release := q.Get("release")
+	if _, ok := releases[release]; release != "" && !ok {
+		http.Error(w, "unknown release", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := mappingFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	log.Println("Document: ", fname)
 	log.Println("Translate from", fromField, "/", fromID, "to", toID)
@@ -198,7 +289,14 @@ func translateHandler(w http.ResponseWriter, r *http.Request) {
 		ToSource:     toID,
 		Replace:      true,
 		DropMissing:  true,
-		OutputFormat: "csv",
+		OutputFormat: outFormat,
+		HeaderRow:    headerRow,
+		Delimiters:   delims,
+		KeepEncoding: q.Get("encoding") == "original",
+		Tables:       q["table"],
+		Provenance:   q.Get("provenance") == "1",
+		Release:      release,
+		Filter:       filter,
 	})
 
 	http.Redirect(w, r, "/wait?k="+token, http.StatusSeeOther)
@@ -302,11 +400,34 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	zw.Close()
 }
 
+// preloadOptions parses the -preload and -preload-mb flags.
+func preloadOptions(preload string, limitMB int64) (*sources.OpenOptions, error) {
+	opts := &sources.OpenOptions{MaxPreloadBytes: limitMB << 20}
+	for _, p := range strings.Split(preload, ",") {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+		case p == "all":
+			opts.PreloadAll = true
+		default:
+			parts := strings.Split(p, ":")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid preload mapping %q (expected left:right)", p)
+			}
+			opts.Preload = append(opts.Preload, [2]string{parts[0], parts[1]})
+		}
+	}
+	return opts, nil
+}
+
 // http://localhost:8080/translate?field=R2VuZV9JRA&from=gov.nih.nlm.ncbi.gene&to=org.ensembl.gene
 
 func main() {
 	dbname := flag.String("db", "sources.sqlite", "database `filename` to load source datasets")
 	addr := flag.String("i", ":8080", "`address:port` to listen for web requests")
+	releaseDir := flag.String("releases", "", "`directory` of source database release snapshots to load")
+	preload := flag.String("preload", "", "`mappings` to hold in memory, as comma-separated left:right source pairs, or all")
+	preloadMB := flag.Int64("preload-mb", 0, "approximate memory `limit` in MB of the preloaded mappings of the database and of each release (0=unlimited)")
 	flag.Parse()
 
 	err := databio.CheckDirectories()
@@ -314,12 +435,27 @@ func main() {
 		log.Fatal(err)
 	}
 
-	srcDB, err = sources.Open(*dbname)
+	opts, err := preloadOptions(*preload, *preloadMB)
 	if err != nil {
 		log.Fatal(err)
 	}
+	srcDB, err = sources.OpenWithOptions(*dbname, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n := srcDB.PreloadedBytes(); n > 0 {
+		log.Printf("Preloaded mappings use about %.1f MB", float64(n)/(1<<20))
+	}
+	if *releaseDir != "" {
+		releases, err = sources.OpenReleases(*releaseDir, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Loaded releases", releases.IDs())
+	}
 	detector = detection.NewDetector(srcDB)
 	mapper = mapping.NewMapper(srcDB)
+	mapper.SetReleases(releases)
 
 	templates = template.New("databio")
 	templates.Funcs(template.FuncMap{
@@ -332,6 +468,9 @@ func main() {
 		"pct": func(v float64) string {
 			return fmt.Sprintf("%0.2f%%", v*100.0)
 		},
+		"releases": func() []string {
+			return releases.IDs()
+		},
 		"safe": func(h string) template.HTML {
 			return template.HTML(h)
 		},
@@ -353,6 +492,7 @@ func main() {
 	http.HandleFunc("/", indexHandler)              // index.html => POST to /upload
 	http.HandleFunc("/upload", uploadHandler)       // file upload => redirect to /report
 	http.HandleFunc("/report", reportHandler)       // report.html => POST to /translate
+	http.HandleFunc("/header", headerHandler)       // choose the header row => redirect to /report
 	http.HandleFunc("/quickmap", quickmapHandler)   // in-page quick translation call for mapping preview
 	http.HandleFunc("/translate", translateHandler) // begin translation => redirect to /wait
 	http.HandleFunc("/wait", waitHandler)           // translate.html => GET to /download
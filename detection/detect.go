@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/joiningdata/databio"
@@ -43,8 +42,17 @@ type FieldInfo struct {
 	// Type of the Field (floats, integers, prefixed integers, text, etc)
 	Type string
 
+	// ValueType of the Field's values as inferred by the input format
+	// (see formats.Schema).
+	ValueType formats.FieldType `json:",omitempty"`
+
 	// Order of the field in the record.
 	Order int
+
+	// Delimiter separates multiple values within the Field, or is empty
+	// if the Field is single-valued. It can be overridden when mapping
+	// (see mapping.Options).
+	Delimiter string `json:",omitempty"`
 }
 
 // Result encodes the results of a detection task on a data file.
@@ -55,6 +63,12 @@ type Result struct {
 	// InputFilename is the source filename (relative to upload directory).
 	InputFilename string `json:"input_file"`
 
+	// Encoding is the character encoding detected in a text input.
+	Encoding string `json:"encoding,omitempty"`
+
+	// Dialect is the syntax detected in a delimited text input.
+	Dialect *formats.Dialect `json:"dialect,omitempty"`
+
 	// DetectedSources reports, for each field of the input, the detected
 	// data Sources, percentage hit ratio, and other stats.
 	DetectedSources map[string]map[string]*sources.SourceHit `json:"detected"`
@@ -62,17 +76,60 @@ type Result struct {
 	// Fields reports the detected data types of each field.
 	Fields []*FieldInfo `json:"fields"`
 
+	// HeaderRow is the index of the row containing the field names,
+	// or -1 if the input has no header row (see formats.HeaderReader).
+	HeaderRow int `json:"header_row"`
+
+	// HeaderConfidence is a score from 0 to 1 for the HeaderRow detection.
+	HeaderConfidence float64 `json:"header_confidence"`
+
+	// Preamble contains the descriptive lines found above the header row
+	// (see formats.PreambleReader).
+	Preamble []string `json:"preamble,omitempty"`
+
 	// Maps reports the possible direct translation destinations for each
 	// data source that was possibly detected in the input.
 	Maps map[string][]string `json:"maps"`
 
 	// Sources is the list of sources used for detection.
 	Sources map[string]*sources.Source `json:"sources"`
+
+	// Tables reports the detection results for each table (e.g. sheets
+	// in a workbook) when the input contains multiple tables. The
+	// DetectedSources and Fields above describe the first table.
+	Tables []*TableResult `json:"tables,omitempty"`
+}
+
+// TableResult encodes the detection results for a single table of a
+// multi-table input.
+type TableResult struct {
+	// Name of the table.
+	Name string `json:"name"`
+
+	// DetectedSources reports, for each field of the table, the detected
+	// data Sources, percentage hit ratio, and other stats.
+	DetectedSources map[string]map[string]*sources.SourceHit `json:"detected"`
+
+	// Fields reports the detected data types of each field.
+	Fields []*FieldInfo `json:"fields"`
+
+	// HeaderRow is the index of the row containing the field names,
+	// or -1 if the table has no header row.
+	HeaderRow int `json:"header_row"`
+
+	// HeaderConfidence is a score from 0 to 1 for the HeaderRow detection.
+	HeaderConfidence float64 `json:"header_confidence"`
+
+	// Preamble contains the descriptive lines found above the header row.
+	Preamble []string `json:"preamble,omitempty"`
 }
 
 type request struct {
 	inputFilename string
 	resultToken   string
+
+	// headerRow overrides header detection if not nil.
+	headerRow *int
 }
 
 ///////////////
@@ -89,6 +146,21 @@ func (d *Detector) Start(fname string) string {
 	return token
 }
 
+// StartWithHeader starts a background detection process on the given
+// filename, using the indexed row as the header (or -1 for no header row)
+// instead of detecting it. Returns a job token that can be used to check
+// job status.
+func (d *Detector) StartWithHeader(fname string, headerRow int) string {
+	token := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s#header=%d", fname, headerRow))))
+	x := request{
+		inputFilename: fname,
+		resultToken:   token,
+		headerRow:     &headerRow,
+	}
+	d.pump <- x
+	return token
+}
+
 // Status checks for a Result using the given job-token.
 func (d *Detector) Status(token string) (res *Result, done bool) {
 	res = &Result{}
@@ -122,28 +194,161 @@ func (d *Detector) runOne(req request) {
 			"error", "unable to read input")
 		return
 	}
+	defer f.Close()
 
 	r, err := formats.Open(f)
 	if err != nil {
 		log.Println("stage1", req, err)
 		databio.PutResult(req.resultToken, "detection",
 			"error", "unable to parse input")
-		f.Close()
 		return
 	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	if er, ok := r.(formats.EncodedReader); ok {
+		res.Encoding = er.Encoding()
+	}
+	if dr, ok := r.(formats.DialectReader); ok {
+		d := dr.Dialect()
+		res.Dialect = &d
+	}
+
+	mr, multi := r.(formats.MultiTableReader)
+	for {
+		t := &TableResult{HeaderRow: 0, HeaderConfidence: 1}
+		if multi {
+			t.Name = mr.Table()
+		}
+		err = d.detectHeader(r, t, req.headerRow)
+		if err == nil {
+			t.Fields, t.DetectedSources, err = d.detectTable(r)
+		}
+		_, headed := r.(formats.HeaderReader)
+		if err == nil && headed && req.headerRow == nil && t.HeaderRow >= 0 {
+			if ratio := d.headerDataRatio(t.DetectedSources); ratio >= 0.5 {
+				// the header looks like data, so try again without one
+				noHeader := -1
+				if err = d.detectHeader(r, t, &noHeader); err == nil {
+					t.HeaderConfidence = ratio
+					t.Fields, t.DetectedSources, err = d.detectTable(r)
+				}
+			}
+		}
+		if err != nil {
+			log.Println("stage2", req, t.Name, err)
+			databio.PutResult(req.resultToken, "detection",
+				"error", "unable to parse input")
+			return
+		}
+		res.Tables = append(res.Tables, t)
+		if !multi {
+			break
+		}
+		if err = mr.NextTable(); err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println("stage2", req, err)
+			databio.PutResult(req.resultToken, "detection",
+				"error", "unable to parse input")
+			return
+		}
+	}
+
+	res.Fields = res.Tables[0].Fields
+	res.DetectedSources = res.Tables[0].DetectedSources
+	res.HeaderRow = res.Tables[0].HeaderRow
+	res.HeaderConfidence = res.Tables[0].HeaderConfidence
+	res.Preamble = res.Tables[0].Preamble
+	res.Maps = make(map[string][]string)
+	for _, t := range res.Tables {
+		for _, sourceHits := range t.DetectedSources {
+			for s := range sourceHits {
+				if _, ok := res.Maps[s]; ok {
+					continue
+				}
+				res.Maps[s] = d.src.Mappings(s)
+			}
+		}
+	}
+	if !multi {
+		res.Tables = nil
+	}
+
+	databio.PutResult(req.resultToken, "detection", res)
+}
+
+// detectHeader records the header row of the current table in r, after
+// overriding it if headerRow is not nil.
+func (d *Detector) detectHeader(r formats.Reader, t *TableResult, headerRow *int) error {
+	hr, ok := r.(formats.HeaderReader)
+	if !ok {
+		return nil
+	}
+	if headerRow != nil {
+		if err := hr.SetHeaderRow(*headerRow); err != nil {
+			return err
+		}
+	}
+	t.HeaderRow = hr.HeaderRow()
+	t.HeaderConfidence = hr.HeaderConfidence()
+	if pr, ok := r.(formats.PreambleReader); ok {
+		t.Preamble = pr.Preamble()
+	}
+	return nil
+}
 
+// headerDataRatio returns the fraction of identified fields that have a
+// header which is itself an identifier from the detected sources, as happens
+// when the first row of a headerless table is used as the header.
+func (d *Detector) headerDataRatio(colsrcs map[string]map[string]*sources.SourceHit) float64 {
+	identified, hits := 0, 0
+	for header, srcs := range colsrcs {
+		if len(srcs) == 0 {
+			continue
+		}
+		identified++
+		for _, sh := range d.src.DetermineSource([]string{header}) {
+			if _, ok := srcs[sh.SourceName]; ok {
+				hits++
+				break
+			}
+		}
+	}
+	if identified == 0 {
+		return 0
+	}
+	return float64(hits) / float64(identified)
+}
+
+// detectTable samples the records of the current table in r, and
+// determines the data type and likely data sources of each field.
+func (d *Detector) detectTable(r formats.Reader) ([]*FieldInfo, map[string]map[string]*sources.SourceHit, error) {
 	///// collect a sample of the input records
 	samples := make(map[string][]string)
 	n := 0
 	rec, err := r.Next()
 	var coltypes []*FieldInfo
 	if err == nil {
+		var delims map[string]string
+		if mv, ok := r.(formats.MultiValueReader); ok {
+			delims = mv.Delimiters()
+		}
+		var schema formats.Schema
+		if sr, ok := r.(formats.SchemaReader); ok {
+			schema = sr.Schema()
+		}
 		coltypes = make([]*FieldInfo, len(rec.Fields()))
 		for i, colname := range rec.Fields() {
 			coltypes[i] = &FieldInfo{
-				Header: colname,
-				Type:   "text",
-				Order:  i,
+				Header:    colname,
+				Type:      "text",
+				Order:     i,
+				Delimiter: delims[colname],
+			}
+			if f := schema.Field(colname); f != nil {
+				coltypes[i].ValueType = f.Type
 			}
 		}
 	}
@@ -162,12 +367,8 @@ func (d *Detector) runOne(req request) {
 
 		rec, err = r.Next()
 	}
-	f.Close()
 	if err != nil && err != io.EOF {
-		log.Println("stage2", req, err)
-		databio.PutResult(req.resultToken, "detection",
-			"error", "unable to parse input")
-		return
+		return nil, nil, err
 	}
 
 	///// determine if each column is numeric or text
@@ -179,14 +380,12 @@ func (d *Detector) runOne(req request) {
 		nPrefixedIntegers := 0
 
 		for _, s := range sample {
-			_, err := strconv.ParseInt(s, 10, 64)
-			if err == nil {
+			switch formats.ValueType(s) {
+			case formats.TypeInt:
 				nIntegers++
 				nFloats++
 				continue
-			}
-			_, err = strconv.ParseFloat(s, 64)
-			if err == nil {
+			case formats.TypeFloat:
 				nFloats++
 				continue
 			}
@@ -204,28 +403,18 @@ func (d *Detector) runOne(req request) {
 		} else if nPrefixedIntegers >= len(sample)/2 {
 			colinfo.Type = "prefixed integers"
 		}
+		if colinfo.ValueType == "" {
+			colinfo.ValueType = formats.InferType(sample)
+		}
 	}
 
 	////////////
 	// try to classify each column's source
 	colsrcs := make(map[string]map[string]*sources.SourceHit)
-	sourcemaps := make(map[string][]string)
 	for _, colinfo := range coltypes {
 		sample := samples[colinfo.Header]
-		sourceHits := d.identify(colinfo.Type, sample)
-		colsrcs[colinfo.Header] = sourceHits
-
-		for s := range sourceHits {
-			if _, ok := sourcemaps[s]; ok {
-				continue
-			}
-			sourcemaps[s] = d.src.Mappings(s)
-		}
+		colsrcs[colinfo.Header] = d.identify(colinfo.Type, sample)
 	}
 
-	res.DetectedSources = colsrcs
-	res.Maps = sourcemaps
-	res.Fields = coltypes
-
-	databio.PutResult(req.resultToken, "detection", res)
+	return coltypes, colsrcs, nil
 }
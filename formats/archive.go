@@ -0,0 +1,320 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	_ = Register(&Format{
+		Name:        "ZIP",
+		Description: "ZIP Archive of supported files",
+		Extensions:  []string{".zip"},
+		MediaTypes:  []string{"application/zip"},
+		Detect:      detectZIP,
+		NewReader: func(r io.Reader) (Reader, error) {
+			// TODO: this'll panic if necessary, but we could do it cleaner later
+			return OpenZIP(r.(io.ReadSeeker))
+		},
+		NewWriter: writerNotSupported,
+	})
+)
+
+func detectZIP(data []byte, incomplete bool) (supported, more bool) {
+	if len(data) < 4 {
+		return false, incomplete
+	}
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) || isODS(data) {
+		return false, false
+	}
+	if incomplete {
+		// excel documents use the same container
+		return true, true
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false, false
+	}
+	for _, zf := range zr.File {
+		if zf.Name == "[Content_Types].xml" {
+			return false, false
+		}
+	}
+	return len(zipMembers(zr)) > 0, false
+}
+
+// ZIP supports reading tabular records from each supported file within
+// a zip archive. Each file is a table named by its path in the archive.
+type ZIP struct {
+	members []*zip.File
+	current int
+
+	r   Reader
+	tmp *os.File
+
+	// multi-value delimiters to use in every file
+	delims map[string]string
+
+	stickyErr error
+}
+
+// OpenZIP opens a zip archive and returns a formats.Reader.
+func OpenZIP(in io.ReadSeeker) (*ZIP, error) {
+	ra, size, err := readerAt(in)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x := &ZIP{members: zipMembers(zr)}
+	if len(x.members) == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+	x.selectMember(0)
+	return x, x.stickyErr
+}
+
+// zipMembers returns the files within the archive that have the
+// extension of a supported format, sorted by name.
+func zipMembers(zr *zip.Reader) []*zip.File {
+	var res []*zip.File
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || strings.HasPrefix(zf.Name, "__MACOSX/") {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(zf.Name))
+		if ext == "" || ext == ".zip" {
+			continue
+		}
+		if _, err := Lookup(ext); err == nil {
+			res = append(res, zf)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// selectMember extracts the numbered file to a temporary location
+// and opens it for reading.
+func (x *ZIP) selectMember(i int) {
+	x.current = i
+	if c, ok := x.r.(io.Closer); ok {
+		c.Close()
+	}
+	x.r = nil
+	if x.stickyErr = x.removeTemp(); x.stickyErr != nil {
+		return
+	}
+
+	zf := x.members[i]
+	rc, err := zf.Open()
+	if err != nil {
+		x.stickyErr = err
+		return
+	}
+	defer rc.Close()
+
+	x.tmp, x.stickyErr = spoolTemp(rc, path.Ext(zf.Name))
+	if x.stickyErr != nil {
+		return
+	}
+	x.r, x.stickyErr = Open(x.tmp)
+	if mv, ok := x.r.(MultiValueReader); ok {
+		for field, delim := range x.delims {
+			mv.SetDelimiter(field, delim)
+		}
+	}
+}
+
+func (x *ZIP) removeTemp() error {
+	if x.tmp == nil {
+		return nil
+	}
+	x.tmp.Close()
+	err := os.Remove(x.tmp.Name())
+	x.tmp = nil
+	return err
+}
+
+// Tables returns the names of all supported files in the archive.
+// (Implements the formats.MultiTableReader interface)
+func (x *ZIP) Tables() []string {
+	names := make([]string, len(x.members))
+	for i, zf := range x.members {
+		names[i] = zf.Name
+	}
+	return names
+}
+
+// Table returns the name of the current file.
+// (Implements the formats.MultiTableReader interface)
+func (x *ZIP) Table() string {
+	return x.members[x.current].Name
+}
+
+// SelectTable moves to the start of the named file.
+// (Implements the formats.MultiTableReader interface)
+func (x *ZIP) SelectTable(name string) error {
+	for i, zf := range x.members {
+		if zf.Name == name {
+			x.selectMember(i)
+			return x.stickyErr
+		}
+	}
+	return ErrUnknownTable
+}
+
+// NextTable moves to the next supported file in the archive.
+// (Implements the formats.MultiTableReader interface)
+func (x *ZIP) NextTable() error {
+	if x.current+1 >= len(x.members) {
+		return io.EOF
+	}
+	x.selectMember(x.current + 1)
+	return x.stickyErr
+}
+
+// Next returns the next Record in the current file.
+// (Implements the formats.Reader interface)
+func (x *ZIP) Next() (Record, error) {
+	if x.stickyErr != nil {
+		return nil, x.stickyErr
+	}
+	var rec Record
+	rec, x.stickyErr = x.r.Next()
+	return rec, x.stickyErr
+}
+
+// Delimiters returns the multi-value delimiters of the current file.
+// (Implements the formats.MultiValueReader interface)
+func (x *ZIP) Delimiters() map[string]string {
+	if mv, ok := x.r.(MultiValueReader); ok {
+		return mv.Delimiters()
+	}
+	return nil
+}
+
+// SetDelimiter overrides the multi-value delimiter of the named field in
+// every file.
+// (Implements the formats.MultiValueReader interface)
+func (x *ZIP) SetDelimiter(field, delim string) {
+	if x.delims == nil {
+		x.delims = make(map[string]string)
+	}
+	x.delims[field] = delim
+	if mv, ok := x.r.(MultiValueReader); ok {
+		mv.SetDelimiter(field, delim)
+	}
+}
+
+// HeaderRow returns the header row of the current file.
+// (Implements the formats.HeaderReader interface)
+func (x *ZIP) HeaderRow() int {
+	if hr, ok := x.r.(HeaderReader); ok {
+		return hr.HeaderRow()
+	}
+	return 0
+}
+
+// HeaderConfidence returns the confidence of the header row detection
+// in the current file.
+// (Implements the formats.HeaderReader interface)
+func (x *ZIP) HeaderConfidence() float64 {
+	if hr, ok := x.r.(HeaderReader); ok {
+		return hr.HeaderConfidence()
+	}
+	return 1
+}
+
+// SetHeaderRow overrides the header row of the current file.
+// (Implements the formats.HeaderReader interface)
+func (x *ZIP) SetHeaderRow(row int) error {
+	if row < -1 {
+		return ErrHeaderRow
+	}
+	if hr, ok := x.r.(HeaderReader); ok {
+		return hr.SetHeaderRow(row)
+	}
+	return ErrUnsupportedFormat
+}
+
+// Preamble returns the lines above the header row of the current file.
+// (Implements the formats.PreambleReader interface)
+func (x *ZIP) Preamble() []string {
+	if pr, ok := x.r.(PreambleReader); ok {
+		return pr.Preamble()
+	}
+	return nil
+}
+
+// Schema returns the inferred schema of the current file.
+// (Implements the formats.SchemaReader interface)
+func (x *ZIP) Schema() Schema {
+	if sr, ok := x.r.(SchemaReader); ok {
+		return sr.Schema()
+	}
+	return nil
+}
+
+// Err returns the last error that occured.
+func (x *ZIP) Err() error {
+	return x.stickyErr
+}
+
+// Close removes any temporary files.
+func (x *ZIP) Close() error {
+	if c, ok := x.r.(io.Closer); ok {
+		c.Close()
+	}
+	return x.removeTemp()
+}
+
+// spoolTemp copies the input to a new temporary file with the given
+// extension, and returns it positioned at the start. The caller must
+// remove the file when done.
+func spoolTemp(in io.Reader, ext string) (*os.File, error) {
+	f, err := ioutil.TempFile("", "databio-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(f, in); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// readerAt returns random access to the input, reading it into
+// memory if necessary.
+func readerAt(in io.ReadSeeker) (io.ReaderAt, int64, error) {
+	size, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ra, ok := in.(io.ReaderAt); ok {
+		return ra, size, nil
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err = io.Copy(buf, in); err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(buf.Bytes()), size, nil
+}
@@ -1,6 +1,7 @@
 package formats
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/csv"
 	"io"
@@ -11,7 +12,8 @@ const (
 	// check at most 5000 rows for header content
 	csvHeaderCheckMaxRows = 5000
 
-	csvMultiSplit = ";"
+	// used to join multiple values when writing
+	csvMultiSplit = "|"
 )
 
 var (
@@ -25,7 +27,9 @@ var (
 			// TODO: this'll panic if necessary, but we could do it cleaner later
 			return OpenCSV(r.(io.ReadSeeker))
 		},
-		NewWriter: writerNotSupported,
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return NewCSVWriter(w, DefaultDialect), nil
+		},
 	})
 )
 
@@ -37,6 +41,7 @@ func detectCSV(data []byte, incomplete bool) (supported, more bool) {
 		}
 	}()
 
+	data = decodeSample(data)
 	if incomplete {
 		// since we only get a chunk, make sure the last line is a full record
 		idx := bytes.LastIndexByte(data, '\n')
@@ -47,11 +52,12 @@ func detectCSV(data []byte, incomplete bool) (supported, more bool) {
 		data = data[:idx]
 	}
 
-	b := bytes.NewReader(data)
-	r := csv.NewReader(b)
-	// don't validate number of columns in case there's a weird header
-	r.FieldsPerRecord = -1
-	r.ReuseRecord = true
+	d := SniffDialect(data)
+	if d.Delimiter == '\t' {
+		// the (faster) tab-delimited parser will handle it
+		return false, false
+	}
+	r := newDialectReader(bytes.NewReader(data), d)
 	colcounts := make(map[int]int)
 	ncols := 0
 	nlines := 0
@@ -83,24 +89,60 @@ func detectCSV(data []byte, incomplete bool) (supported, more bool) {
 	return false, false
 }
 
+// recordReader is implemented by csv.Reader and dialectLineReader.
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// newDialectReader returns a recordReader for the Dialect.
+func newDialectReader(in io.Reader, d Dialect) recordReader {
+	if d.Quote != '"' {
+		return &dialectLineReader{s: bufio.NewScanner(in), d: d}
+	}
+	r := csv.NewReader(in)
+	r.Comma = d.Delimiter
+	r.Comment = d.Comment
+	// don't validate number of columns in case there's a weird header
+	r.FieldsPerRecord = -1
+	return r
+}
+
 // CSV supports reading tabular records from an csv file.
 type CSV struct {
 	f io.ReadSeeker
-	r *csv.Reader
+	r recordReader
 
-	head []string
+	head    []string
+	enc     string
+	dialect Dialect
+
+	// records read ahead to infer multi-value delimiters
+	pending [][]string
+	multiSplitter
+	headerInfo
 
 	stickyErr error
 }
 
 // OpenCSV opens a csv document and returns a formats.Reader.
 // An io.ReadSeeker is required due to header detection readahead.
+// Input that isn't UTF-8 is transcoded (see DetectEncoding), and the
+// delimiter, quote and comment characters are detected (see SniffDialect).
 func OpenCSV(in io.ReadSeeker) (*CSV, error) {
-	r := csv.NewReader(in)
+	in, enc, err := decodeText(in)
+	if err != nil {
+		return nil, err
+	}
+	d, err := sniffText(in)
+	if err != nil {
+		return nil, err
+	}
 
 	x := &CSV{
-		f: in,
-		r: r,
+		f:       in,
+		r:       newDialectReader(in, d),
+		enc:     enc,
+		dialect: d,
 	}
 
 	x.skipHeaders()
@@ -110,78 +152,210 @@ func OpenCSV(in io.ReadSeeker) (*CSV, error) {
 
 func (x *CSV) skipHeaders() {
 	// if there are descriptive lines etc at the top we try to skip over them
-	cols, err := x.r.Read()
-	if err != nil {
-		x.stickyErr = err
-		return
-	}
-
-	// out of the first N rows, which number of columns is the most frequent?
-	bestcols := 0
-	nr := 0
-	colcounts := make(map[int]int)
-	for err == nil {
-		nr++
-		colcounts[len(cols)]++
-		if colcounts[len(cols)] > colcounts[bestcols] {
-			bestcols = len(cols)
-		}
-		if nr > csvHeaderCheckMaxRows {
+	var rows [][]string
+	for len(rows) <= csvHeaderCheckMaxRows {
+		cols, err := x.r.Read()
+		if err == io.EOF {
 			break
 		}
-		cols, err = x.r.Read()
-	}
-	if err != nil {
-		if err != io.EOF {
+		if err != nil {
 			x.stickyErr = err
 			return
 		}
+		rows = append(rows, cols)
+	}
+	if len(rows) == 0 {
+		x.stickyErr = io.EOF
+		return
 	}
 
 	// TODO: verify that it actually looks like a CSV
 
+	row, confidence := findHeader(rows)
+	x.SetHeaderRow(row)
+	x.headConfidence = confidence
+}
+
+// SetHeaderRow moves to the start of the document and uses the indexed row
+// for field names, or generates field names if row is -1.
+// (Implements the formats.HeaderReader interface)
+func (x *CSV) SetHeaderRow(row int) error {
+	if row < -1 {
+		return ErrHeaderRow
+	}
 	_, x.stickyErr = x.f.Seek(0, io.SeekStart)
 	if x.stickyErr != nil {
-		return
+		return x.stickyErr
+	}
+	x.preamble, x.stickyErr = readPreamble(x.f, row, x.dialect.Comment)
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	_, x.stickyErr = x.f.Seek(0, io.SeekStart)
+	if x.stickyErr != nil {
+		return x.stickyErr
 	}
-	x.r = csv.NewReader(x.f)
+	x.r = newDialectReader(x.f, x.dialect)
+	x.head = nil
+	x.pending = nil
+	x.headRow = row
+	x.headConfidence = 1
 
-	// reset the row iterator and move until we hit first row with that number of columns
-	// assume that that row is the header (TODO: be smarter about this)
-	cols, err = x.r.Read()
-	for err == nil {
-		if len(cols) == bestcols {
-			x.head = cols
-			return
+	for i := 0; i <= row; i++ {
+		x.head, x.stickyErr = x.r.Read()
+		if x.stickyErr != nil {
+			return x.stickyErr
 		}
-		cols, err = x.r.Read()
 	}
-	if err != nil {
-		x.stickyErr = err
-		return
+	x.readAhead()
+	if row == -1 {
+		x.head = generatedHeader(x.pending)
+	}
+	x.inferDelimiters(x.head, x.pending, string(x.dialect.Delimiter))
+	return x.stickyErr
+}
+
+// readAhead reads a sample of records to infer multi-value delimiters.
+func (x *CSV) readAhead() {
+	for len(x.pending) < multiValueSampleRows {
+		cols, err := x.r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			x.stickyErr = err
+			return
+		}
+		x.pending = append(x.pending, cols)
 	}
 }
 
 // Next returns the next Record in the document.
 // (Implements the formats.Reader interface)
 func (x *CSV) Next() (Record, error) {
-	cols, err := x.r.Read()
-	if err != nil {
-		x.stickyErr = err
+	if x.stickyErr != nil {
 		return nil, x.stickyErr
 	}
-	vals := make([][]string, len(cols))
-	for i, c := range cols {
-		vals[i] = strings.Split(c, csvMultiSplit)
+	var cols []string
+	if len(x.pending) > 0 {
+		cols = x.pending[0]
+		x.pending = x.pending[1:]
+	} else {
+		var err error
+		cols, err = x.r.Read()
+		if err != nil {
+			x.stickyErr = err
+			return nil, x.stickyErr
+		}
 	}
 
 	return &simpleRec{
 		fields: x.head,
-		values: vals,
+		values: x.splitRow(x.head, cols),
 	}, nil
 }
 
+// Dialect returns the delimiter, quote and comment characters detected in
+// the document.
+// (Implements the formats.DialectReader interface)
+func (x *CSV) Dialect() Dialect {
+	return x.dialect
+}
+
+// Encoding returns the character encoding detected in the document.
+// (Implements the formats.EncodedReader interface)
+func (x *CSV) Encoding() string {
+	return x.enc
+}
+
 // Err returns the last error that occured.
 func (x *CSV) Err() error {
 	return x.stickyErr
 }
+
+///////////
+
+// CSVWriter serializes records to delimited text in the given Dialect.
+// Any preamble lines are written as-is, followed by a header line using
+// the fields of the first Record. Multiple values are joined with a "|" pipe.
+type CSVWriter struct {
+	w *bufio.Writer
+	d Dialect
+
+	preamble []string
+	wrote    bool
+
+	stickyErr error
+}
+
+// NewCSVWriter returns a formats.Writer that emits delimited text.
+func NewCSVWriter(w io.Writer, d Dialect) *CSVWriter {
+	return &CSVWriter{
+		w: bufio.NewWriter(w),
+		d: d,
+	}
+}
+
+// Write serializes the Record.
+// (Implements the formats.Writer interface)
+func (x *CSVWriter) Write(rec Record) error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	if !x.wrote {
+		x.wrote = true
+		for _, line := range x.preamble {
+			x.w.WriteString(line)
+			x.w.WriteString("\n")
+		}
+		x.writeLine(rec.Fields())
+	}
+
+	line := make([]string, len(rec.Fields()))
+	for i, v := range rec.Fields() {
+		line[i] = strings.Join(rec.Values(v), csvMultiSplit)
+	}
+	x.writeLine(line)
+	return x.stickyErr
+}
+
+// SetPreamble sets the lines written above the header line.
+// (Implements the formats.PreambleWriter interface)
+func (x *CSVWriter) SetPreamble(lines []string) {
+	x.preamble = lines
+}
+
+func (x *CSVWriter) writeLine(cols []string) {
+	special := "\r\n" + string(x.d.Delimiter) + string(x.d.Quote)
+	quote := string(x.d.Quote)
+	for i, c := range cols {
+		if i > 0 {
+			x.w.WriteRune(x.d.Delimiter)
+		}
+		needsQuote := strings.ContainsAny(c, special) ||
+			(i == 0 && x.d.Comment != 0 && strings.HasPrefix(c, string(x.d.Comment)))
+		if !needsQuote || x.d.Quote == 0 {
+			x.w.WriteString(c)
+			continue
+		}
+		x.w.WriteString(quote)
+		x.w.WriteString(strings.Replace(c, quote, quote+quote, -1))
+		x.w.WriteString(quote)
+	}
+	_, x.stickyErr = x.w.WriteString("\n")
+}
+
+// Close flushes any buffered data.
+// (Implements the formats.Writer interface)
+func (x *CSVWriter) Close() error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	x.stickyErr = x.w.Flush()
+	return x.stickyErr
+}
+
+// Err returns the last error that occured.
+func (x *CSVWriter) Err() error {
+	return x.stickyErr
+}
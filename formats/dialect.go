@@ -0,0 +1,198 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+var (
+	// DefaultDialect is the dialect of RFC 4180 comma-separated values.
+	DefaultDialect = Dialect{Delimiter: ',', Quote: '"'}
+
+	// candidate characters for dialect sniffing, in order of preference
+	dialectDelimiters = []rune{',', '\t', ';', '|'}
+	dialectQuotes     = []rune{'"', '\''}
+	dialectComments   = []rune{'#', '%'}
+)
+
+// Dialect describes the syntax of a delimited text document.
+type Dialect struct {
+	// Delimiter separates fields within a record.
+	Delimiter rune `json:"delimiter"`
+
+	// Quote encloses fields that contain delimiters or line breaks.
+	Quote rune `json:"quote"`
+
+	// Comment begins a line which is not a record, or 0 if none.
+	Comment rune `json:"comment,omitempty"`
+}
+
+// DialectReader is a Reader for delimited text documents which reports
+// the dialect detected in the input.
+type DialectReader interface {
+	Reader
+
+	// Dialect returns the dialect detected in the document.
+	Dialect() Dialect
+}
+
+// SniffDialect determines the most likely dialect of a sample of delimited
+// text. The delimiter is the candidate which results in the most lines
+// having the same number of fields. Comment lines are those starting with a
+// candidate prefix that never has that number of fields.
+func SniffDialect(data []byte) Dialect {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	d := DefaultDialect
+	bestLines, bestCols := 0, 0
+	for _, delim := range dialectDelimiters {
+		cols, n := dialectMode(lines, Dialect{Delimiter: delim, Quote: '"'})
+		if cols > 1 && n > bestLines {
+			d.Delimiter = delim
+			bestLines, bestCols = n, cols
+		}
+	}
+
+	// quotes are only found at the boundaries of fields
+	bestQuotes := 0
+	for _, quote := range dialectQuotes {
+		n := 0
+		for _, line := range lines {
+			n += countBoundaryQuotes(line, d.Delimiter, quote)
+		}
+		if n > bestQuotes {
+			d.Quote = quote
+			bestQuotes = n
+		}
+	}
+
+	for _, comment := range dialectComments {
+		n := 0
+		for _, line := range lines {
+			if !strings.HasPrefix(line, string(comment)) {
+				continue
+			}
+			if len(splitDialect(line, d)) == bestCols {
+				// probably a header, e.g. "#id,name"
+				n = 0
+				break
+			}
+			n++
+		}
+		if n > 0 {
+			d.Comment = comment
+			break
+		}
+	}
+	return d
+}
+
+// dialectMode returns the most frequent number of fields in the lines,
+// and the number of lines with that many fields.
+func dialectMode(lines []string, d Dialect) (cols, n int) {
+	colcounts := make(map[int]int)
+	for _, line := range lines {
+		c := len(splitDialect(line, d))
+		colcounts[c]++
+		if colcounts[c] > n || (colcounts[c] == n && c > cols) {
+			cols, n = c, colcounts[c]
+		}
+	}
+	return cols, n
+}
+
+// countBoundaryQuotes counts the quote characters that start or end a field.
+func countBoundaryQuotes(line string, delim, quote rune) int {
+	n := 0
+	for _, field := range strings.Split(line, string(delim)) {
+		field = strings.TrimSpace(field)
+		if len(field) >= 2 && strings.HasPrefix(field, string(quote)) &&
+			strings.HasSuffix(field, string(quote)) {
+			n++
+		}
+	}
+	return n
+}
+
+// splitDialect splits a single line into fields.
+func splitDialect(line string, d Dialect) []string {
+	var fields []string
+	var field strings.Builder
+	quoted := false
+	start := true
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case quoted && c == d.Quote:
+			if i+1 < len(rs) && rs[i+1] == d.Quote {
+				field.WriteRune(c)
+				i++
+			} else {
+				quoted = false
+			}
+		case quoted:
+			field.WriteRune(c)
+		case start && c == d.Quote && d.Quote != 0:
+			quoted = true
+			start = false
+		case c == d.Delimiter:
+			fields = append(fields, field.String())
+			field.Reset()
+			start = true
+		default:
+			field.WriteRune(c)
+			start = false
+		}
+	}
+	return append(fields, field.String())
+}
+
+// sniffText returns the dialect of the start of a text document.
+func sniffText(in io.ReadSeeker) (Dialect, error) {
+	sample := make([]byte, encodingSniffBytes)
+	n, err := io.ReadFull(in, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return DefaultDialect, err
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return DefaultDialect, err
+	}
+	sample = sample[:n]
+	if n == encodingSniffBytes {
+		// drop the partial last line
+		if idx := bytes.LastIndexByte(sample, '\n'); idx != -1 {
+			sample = sample[:idx]
+		}
+	}
+	return SniffDialect(sample), nil
+}
+
+// dialectLineReader reads records from documents using quote characters
+// that encoding/csv does not support. Quoted fields can't contain line breaks.
+type dialectLineReader struct {
+	s *bufio.Scanner
+	d Dialect
+}
+
+func (r *dialectLineReader) Read() ([]string, error) {
+	for r.s.Scan() {
+		line := strings.TrimSuffix(r.s.Text(), "\r")
+		if line == "" || (r.d.Comment != 0 && strings.HasPrefix(line, string(r.d.Comment))) {
+			continue
+		}
+		return splitDialect(line, r.d), nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,54 @@
+package formats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSniffDialect(t *testing.T) {
+	for _, tc := range []struct {
+		name, data string
+		want       Dialect
+	}{
+		{"comma", "id,name\n1,a\n2,b\n", Dialect{Delimiter: ',', Quote: '"'}},
+		{"semicolon", "id;name;score\n1;a,b;1,5\n2;c;2,0\n", Dialect{Delimiter: ';', Quote: '"'}},
+		{"pipe", "id|name\n1|a\n2|b\n", Dialect{Delimiter: '|', Quote: '"'}},
+		{"tab", "id\tname\n1\ta\n2\tb\n", Dialect{Delimiter: '\t', Quote: '"'}},
+		{"single quotes", "id,name\n1,'a, b'\n2,'c'\n", Dialect{Delimiter: ',', Quote: '\''}},
+		{"comments", "# exported\n# by hand\nid,name\n1,a\n2,b\n", Dialect{Delimiter: ',', Quote: '"', Comment: '#'}},
+		{"commented header", "#id,name\n1,a\n2,b\n", Dialect{Delimiter: ',', Quote: '"'}},
+		{"crlf", "id;name\r\n1;a\r\n2;b\r\n", Dialect{Delimiter: ';', Quote: '"'}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := SniffDialect([]byte(tc.data)); got != tc.want {
+				t.Errorf("SniffDialect = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadDialect(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, first string
+		dialect              Dialect
+	}{
+		{"semicolon", "id;name\n1;a b\n2;c\n", "a b", Dialect{Delimiter: ';', Quote: '"'}},
+		{"single quotes", "id,name\n1,'a, b'\n2,'c'\n", "a, b", Dialect{Delimiter: ',', Quote: '\''}},
+		{"comments", "% generated\nid,name\n1,\"a, b\"\n% skipped\n2,c\n", "a, b", Dialect{Delimiter: ',', Quote: '"', Comment: '%'}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := openString(t, ".csv", tc.content)
+			if got := r.(DialectReader).Dialect(); got != tc.dialect {
+				t.Errorf("Dialect() = %+v, want %+v", got, tc.dialect)
+			}
+			got := readAll(t, r)
+			want := []map[string]string{
+				{"id": "1", "name": tc.first},
+				{"id": "2", "name": "c"},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("records = %v, want %v", got, want)
+			}
+		})
+	}
+}
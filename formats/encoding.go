@@ -0,0 +1,232 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Character encodings detected in text documents. Encodings that were
+// detected using a byte order mark have EncodingBOMSuffix appended.
+const (
+	EncodingUTF8        = "UTF-8"
+	EncodingUTF16LE     = "UTF-16LE"
+	EncodingUTF16BE     = "UTF-16BE"
+	EncodingWindows1252 = "Windows-1252"
+	EncodingLatin1      = "ISO-8859-1"
+
+	EncodingBOMSuffix = " with BOM"
+)
+
+// number of bytes to examine when sniffing the encoding
+const encodingSniffBytes = 64 << 10
+
+var (
+	// ErrUnsupportedEncoding is returned for unknown character encoding names.
+	ErrUnsupportedEncoding = errors.New("databio/formats: unsupported character encoding")
+
+	errTranscodeSeek = errors.New("databio/formats: can only seek to the start of transcoded input")
+
+	byteOrderMarks = []struct {
+		name string
+		bom  []byte
+	}{
+		{EncodingUTF8, []byte{0xef, 0xbb, 0xbf}},
+		{EncodingUTF16LE, []byte{0xff, 0xfe}},
+		{EncodingUTF16BE, []byte{0xfe, 0xff}},
+	}
+)
+
+// EncodedReader is a Reader for text documents which have been transcoded
+// to UTF-8 from the original character encoding.
+type EncodedReader interface {
+	Reader
+
+	// Encoding returns the character encoding detected in the document.
+	Encoding() string
+}
+
+// DetectEncoding determines the character encoding of a (possibly
+// incomplete) sample of text using byte order marks and heuristics.
+func DetectEncoding(data []byte) string {
+	for _, b := range byteOrderMarks {
+		if bytes.HasPrefix(data, b.bom) {
+			return b.name + EncodingBOMSuffix
+		}
+	}
+
+	// ascii text in UTF-16 has a zero byte in every other position
+	var zeros [2]int
+	for i, c := range data {
+		if c == 0 {
+			zeros[i%2]++
+		}
+	}
+	half := len(data) / 2
+	if half > 0 {
+		if zeros[1]*3 > half && zeros[0]*50 < half {
+			return EncodingUTF16LE
+		}
+		if zeros[0]*3 > half && zeros[1]*50 < half {
+			return EncodingUTF16BE
+		}
+	}
+
+	// ignore a partial character at the end of the sample
+	if n := len(data) - utf8.UTFMax; n > 0 {
+		for i := len(data) - 1; i >= n; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					data = data[:i]
+				}
+				break
+			}
+		}
+	}
+	if utf8.Valid(data) {
+		return EncodingUTF8
+	}
+
+	// windows-1252 uses the C1 control range for printable characters
+	for _, c := range data {
+		if c >= 0x80 && c <= 0x9f {
+			return EncodingWindows1252
+		}
+	}
+	return EncodingLatin1
+}
+
+// lookupEncoding returns the named character encoding, and the byte order
+// mark to use if any.
+func lookupEncoding(name string) (encoding.Encoding, []byte, error) {
+	var bom []byte
+	if strings.HasSuffix(name, EncodingBOMSuffix) {
+		name = strings.TrimSuffix(name, EncodingBOMSuffix)
+		for _, b := range byteOrderMarks {
+			if b.name == name {
+				bom = b.bom
+			}
+		}
+		if bom == nil {
+			return nil, nil, ErrUnsupportedEncoding
+		}
+	}
+
+	switch name {
+	case EncodingUTF8, "":
+		return unicode.UTF8, bom, nil
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), bom, nil
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), bom, nil
+	case EncodingWindows1252:
+		return charmap.Windows1252, bom, nil
+	case EncodingLatin1:
+		return charmap.ISO8859_1, bom, nil
+	}
+	return nil, nil, ErrUnsupportedEncoding
+}
+
+// decodeSample converts a sample of text to UTF-8 for format detection.
+func decodeSample(data []byte) []byte {
+	enc, bom, err := lookupEncoding(DetectEncoding(data))
+	if err != nil || enc == unicode.UTF8 {
+		return bytes.TrimPrefix(data, bom)
+	}
+	res, _, err := transform.Bytes(enc.NewDecoder(), data[len(bom):])
+	if err != nil {
+		return data
+	}
+	return res
+}
+
+// transcoder converts a text document to UTF-8 as it is read. It can only
+// seek to the start of the document.
+type transcoder struct {
+	in   io.ReadSeeker
+	enc  encoding.Encoding
+	skip int64
+
+	r io.Reader
+}
+
+// decodeText detects the character encoding of a text document and returns
+// a reader that converts it to UTF-8, along with the detected encoding.
+func decodeText(in io.ReadSeeker) (io.ReadSeeker, string, error) {
+	sample := make([]byte, encodingSniffBytes)
+	n, err := io.ReadFull(in, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", err
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	name := DetectEncoding(sample[:n])
+	if name == EncodingUTF8 {
+		return in, name, nil
+	}
+	enc, bom, err := lookupEncoding(name)
+	if err != nil {
+		return nil, "", err
+	}
+	t := &transcoder{in: in, enc: enc, skip: int64(len(bom))}
+	if _, err = t.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return t, name, nil
+}
+
+func (t *transcoder) Read(p []byte) (int, error) {
+	return t.r.Read(p)
+}
+
+func (t *transcoder) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errTranscodeSeek
+	}
+	if _, err := t.in.Seek(t.skip, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if t.enc == unicode.UTF8 {
+		t.r = t.in
+	} else {
+		t.r = transform.NewReader(t.in, t.enc.NewDecoder())
+	}
+	return 0, nil
+}
+
+// NewEncodingWriter returns a writer that converts UTF-8 text to the
+// named character encoding (as returned by DetectEncoding). Characters
+// that can't be represented in the encoding are replaced. Close must be
+// called to flush any buffered data, it does not close w.
+func NewEncodingWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	enc, bom, err := lookupEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(bom) > 0 {
+		if _, err = w.Write(bom); err != nil {
+			return nil, err
+		}
+	}
+	if enc == unicode.UTF8 {
+		return nopWriteCloser{w}, nil
+	}
+	return transform.NewWriter(w, encoding.ReplaceUnsupported(enc.NewEncoder())), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package formats
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// utf16LE encodes ascii text as UTF-16LE.
+func utf16LE(s string) string {
+	b := make([]byte, 0, 2*len(s))
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i], 0)
+	}
+	return string(b)
+}
+
+func TestDetectEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, want string
+	}{
+		{"ascii", "id,name\n1,a\n", EncodingUTF8},
+		{"utf-8", "id,name\n1,caf\xc3\xa9\n", EncodingUTF8},
+		{"utf-8 bom", "\xef\xbb\xbfid,name\n", EncodingUTF8 + EncodingBOMSuffix},
+		{"utf-16le bom", "\xff\xfe" + utf16LE("id,name\n"), EncodingUTF16LE + EncodingBOMSuffix},
+		{"utf-16be bom", "\xfe\xff\x00i\x00d", EncodingUTF16BE + EncodingBOMSuffix},
+		{"utf-16le", utf16LE("id,name\n1,a\n"), EncodingUTF16LE},
+		{"utf-16be", "\x00" + utf16LE("id,name\n1,a\n")[:23], EncodingUTF16BE},
+		{"windows-1252", "id,price\n1,\x805\n", EncodingWindows1252},
+		{"latin-1", "id,name\n1,caf\xe9\n", EncodingLatin1},
+		{"truncated utf-8", "id,name\n1,caf\xc3", EncodingUTF8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DetectEncoding([]byte(tc.data)); got != tc.want {
+				t.Errorf("DetectEncoding = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadEncoded(t *testing.T) {
+	for _, tc := range []struct {
+		name, ext, content, enc string
+	}{
+		{"utf-16le bom csv", ".csv", "\xff\xfe" + utf16LE("id,name\n1,cafe\n2,tea\n"), EncodingUTF16LE + EncodingBOMSuffix},
+		{"utf-8 bom tsv", ".tsv", "\xef\xbb\xbfid\tname\n1\tcafe\n2\ttea\n", EncodingUTF8 + EncodingBOMSuffix},
+		{"latin-1 csv", ".csv", "id,name\n1,caf\xe9\n2,tea\n", EncodingLatin1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := openString(t, tc.ext, tc.content)
+			if got := r.(EncodedReader).Encoding(); got != tc.enc {
+				t.Errorf("Encoding() = %q, want %q", got, tc.enc)
+			}
+			rec, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rec.Fields(), []string{"id", "name"}) {
+				t.Errorf("fields = %q", rec.Fields())
+			}
+			want := "cafe"
+			if tc.enc == EncodingLatin1 {
+				want = "café"
+			}
+			if got := rec.Values("name"); len(got) != 1 || got[0] != want {
+				t.Errorf("name = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodingWriter(t *testing.T) {
+	for _, tc := range []struct {
+		enc, want string
+	}{
+		{EncodingUTF8, "café"},
+		{EncodingUTF8 + EncodingBOMSuffix, "\xef\xbb\xbfcaf\xc3\xa9"},
+		{EncodingUTF16LE + EncodingBOMSuffix, "\xff\xfe" + utf16LE("caf") + "\xe9\x00"},
+		{EncodingLatin1, "caf\xe9"},
+		{EncodingWindows1252, "caf\xe9"},
+	} {
+		t.Run(tc.enc, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewEncodingWriter(&buf, tc.enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = w.Write([]byte("café")); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("wrote %q, want %q", buf.String(), tc.want)
+			}
+			if got := DetectEncoding(buf.Bytes()); tc.enc != EncodingWindows1252 && got != tc.enc {
+				t.Errorf("DetectEncoding of output = %q, want %q", got, tc.enc)
+			}
+		})
+	}
+
+	if _, err := NewEncodingWriter(&bytes.Buffer{}, "EBCDIC"); err != ErrUnsupportedEncoding {
+		t.Errorf("NewEncodingWriter(EBCDIC) = %v, want ErrUnsupportedEncoding", err)
+	}
+}
@@ -1,11 +1,13 @@
 package formats
 
 import (
+	"archive/zip"
 	"bytes"
-	"fmt"
 	"io"
-	"log"
+	"os"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/360EntSecGroup-Skylar/excelize"
 )
@@ -28,7 +30,9 @@ var (
 			// TODO: this'll panic if necessary, but we could do it cleaner later
 			return OpenXLSX(r.(io.ReadSeeker))
 		},
-		NewWriter: writerNotSupported,
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return NewXLSXWriter(w), nil
+		},
 	})
 )
 
@@ -41,44 +45,73 @@ func detectXLSX(data []byte, incomplete bool) (supported, more bool) {
 	}()
 
 	if incomplete {
+		if isODS(data) {
+			// same zip container, different contents
+			return false, false
+		}
 		hasMagic := (data[0] == 0x50) && (data[1] == 0x4b) && (data[2] == 0x03) && (data[3] == 0x04)
 		return hasMagic, true
 	}
 
-	_, err := excelize.OpenReader(bytes.NewReader(data))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false, false
+	}
+	_, err = openXLSXWorkbook(zr)
 	return err == nil, false
 }
 
 // XLSX supports reading tabular records from an excel file.
+//
+// Sheets are parsed as they are read from the file, so only the shared
+// strings table and a sample of rows are held in memory at a time. Input
+// that isn't a file is spooled to a temporary file first, which is removed
+// by Close.
 type XLSX struct {
-	f *excelize.File
+	wb  *xlsxWorkbook
+	tmp *os.File
 
 	currentSheet int
-	sheetMap     map[int]string
 
 	head []string
-	rows *excelize.Rows
+	rows *xlsxRows
+
+	// records read ahead to infer multi-value delimiters
+	pending [][]string
+	multiSplitter
+	headerInfo
 
 	stickyErr error
 }
 
 // OpenXLSX opens an excel document and returns a formats.Reader.
 func OpenXLSX(in io.Reader) (*XLSX, error) {
-	// excelize.OpenReader reads the entire file into memory,
-	// so we're done with 'in' when this finishes
-	f, err := excelize.OpenReader(in)
+	x := &XLSX{}
+	ra, ok := in.(io.ReaderAt)
+	rs, seekable := in.(io.Seeker)
+	if !ok || !seekable {
+		var err error
+		if x.tmp, err = spoolTemp(in, ".xlsx"); err != nil {
+			return nil, err
+		}
+		ra, rs = x.tmp, x.tmp
+	}
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		x.Close()
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
 	if err != nil {
+		x.Close()
 		return nil, ErrUnsupportedFormat
 	}
-
-	x := &XLSX{
-		f:            f,
-		currentSheet: 1,
-		sheetMap:     f.GetSheetMap(),
+	x.wb, err = openXLSXWorkbook(zr)
+	if err != nil {
+		x.Close()
+		return nil, ErrUnsupportedFormat
 	}
 
-	log.Printf("+%v", x.sheetMap)
-
 	x.skipHeaders()
 
 	return x, x.stickyErr
@@ -86,114 +119,175 @@ func OpenXLSX(in io.Reader) (*XLSX, error) {
 
 // NextSheet moves to the next Sheet in an excel document.
 func (x *XLSX) NextSheet() error {
-	x.currentSheet++
-	if _, ok := x.sheetMap[x.currentSheet]; !ok {
+	if x.currentSheet+1 >= len(x.wb.sheets) {
 		return io.EOF
 	}
+	x.currentSheet++
 	x.head = nil
 	x.skipHeaders()
 	return x.stickyErr
 }
 
+// Tables returns the names of all sheets in the document.
+// (Implements the formats.MultiTableReader interface)
+func (x *XLSX) Tables() []string {
+	names := make([]string, len(x.wb.sheets))
+	for i, s := range x.wb.sheets {
+		names[i] = s.name
+	}
+	return names
+}
+
+// Table returns the name of the current sheet.
+// (Implements the formats.MultiTableReader interface)
+func (x *XLSX) Table() string {
+	return x.wb.sheets[x.currentSheet].name
+}
+
+// SelectTable moves to the start of the named sheet.
+// (Implements the formats.MultiTableReader interface)
+func (x *XLSX) SelectTable(name string) error {
+	for i, s := range x.wb.sheets {
+		if s.name == name {
+			x.currentSheet = i
+			x.head = nil
+			x.skipHeaders()
+			return x.stickyErr
+		}
+	}
+	return ErrUnknownTable
+}
+
+// NextTable moves to the next sheet in the document.
+// (Implements the formats.MultiTableReader interface)
+func (x *XLSX) NextTable() error {
+	return x.NextSheet()
+}
+
+// openSheet starts reading the current sheet from the first row.
+func (x *XLSX) openSheet() {
+	if x.rows != nil {
+		x.rows.Close()
+	}
+	x.rows, x.stickyErr = x.wb.rows(x.currentSheet)
+}
+
 func (x *XLSX) skipHeaders() {
 	// if there are descriptive lines etc at the top we try to skip over them
-	x.rows, x.stickyErr = x.f.Rows(x.sheetMap[x.currentSheet])
+	x.openSheet()
 	if x.stickyErr != nil {
 		return
 	}
 
-	// out of the first N rows, which number of columns is the most frequent?
-	bestcols := 0
-	nr := 0
-	colcounts := make(map[int]int)
-	for x.rows.Next() {
+	var rows [][]string
+	for len(rows) <= xlsxHeaderCheckMaxRows && x.rows.Next() {
 		cols, err := x.rows.Columns()
 		if err != nil {
 			x.stickyErr = err
 			return
 		}
-		truecols := make([]string, 0, len(cols))
-		for i, c := range cols {
-			if strings.TrimSpace(c) == "" {
-				continue
-			}
-			for i > len(truecols) {
-				truecols = append(truecols, fmt.Sprintf("Column %c", 'A'+len(truecols)))
-			}
-			truecols = append(truecols, strings.TrimSpace(c))
-		}
-		cols = truecols
-
-		nr++
-		colcounts[len(cols)]++
-		if colcounts[len(cols)] > colcounts[bestcols] {
-			bestcols = len(cols)
-		}
-		if nr > xlsxHeaderCheckMaxRows {
-			break
-		}
+		rows = append(rows, trimSheetRow(cols))
+	}
+	if x.stickyErr = x.rows.Error(); x.stickyErr != nil {
+		return
 	}
-	log.Println("BEST COLS ", colcounts)
 
-	// reset the row iterator and move until we hit first row with that number of columns
-	// assume that that row is the header (TODO: be smarter about this)
-	x.rows, x.stickyErr = x.f.Rows(x.sheetMap[x.currentSheet])
+	row, confidence := findHeader(rows)
+	x.SetHeaderRow(row)
+	x.headConfidence = confidence
+}
+
+// SetHeaderRow moves to the start of the sheet and uses the indexed row
+// for field names, or generates field names if row is -1.
+// (Implements the formats.HeaderReader interface)
+func (x *XLSX) SetHeaderRow(row int) error {
+	if row < -1 {
+		return ErrHeaderRow
+	}
+	// reset the row iterator and move to the header
+	x.openSheet()
 	if x.stickyErr != nil {
-		return
+		return x.stickyErr
 	}
-	for x.rows.Next() {
+	x.head = nil
+	x.pending = nil
+	x.headRow = row
+	x.headConfidence = 1
+
+	var above [][]string
+	for i := 0; i <= row; i++ {
+		if !x.rows.Next() {
+			x.stickyErr = x.rows.Error()
+			if x.stickyErr == nil {
+				x.stickyErr = io.EOF
+			}
+			return x.stickyErr
+		}
 		cols, err := x.rows.Columns()
 		if err != nil {
 			x.stickyErr = err
-			return
+			return x.stickyErr
 		}
-		truecols := make([]string, 0, len(cols))
-		for i, c := range cols {
-			if strings.TrimSpace(c) == "" {
-				continue
-			}
-			for i > len(truecols) {
-				truecols = append(truecols, fmt.Sprintf("Column %c", 'A'+len(truecols)))
-			}
-			truecols = append(truecols, strings.TrimSpace(c))
+		if i < row {
+			above = append(above, cols)
 		}
-		cols = truecols
+		x.head = trimSheetRow(cols)
+	}
+	x.preamble = sheetPreamble(above)
+	x.readAhead()
+	if row == -1 {
+		x.head = generatedHeader(x.pending)
+	}
+	x.inferDelimiters(x.head, x.pending, "")
+	return x.stickyErr
+}
 
-		if len(cols) == bestcols {
-			x.head = cols
+// readAhead reads a sample of records to infer multi-value delimiters.
+func (x *XLSX) readAhead() {
+	for len(x.pending) < multiValueSampleRows && x.rows.Next() {
+		cols, err := x.rows.Columns()
+		if err != nil {
+			x.stickyErr = err
 			return
 		}
+		x.pending = append(x.pending, cols)
 	}
+	x.stickyErr = x.rows.Error()
 }
 
 // Next returns the next Record in the document.
 // (Implements the formats.Reader interface)
 func (x *XLSX) Next() (Record, error) {
-	if !x.rows.Next() {
-		x.stickyErr = x.rows.Error()
-		if x.stickyErr == nil {
-			x.stickyErr = io.EOF
-		}
+	if x.stickyErr != nil {
 		return nil, x.stickyErr
 	}
+	var cols []string
+	if len(x.pending) > 0 {
+		cols = x.pending[0]
+		x.pending = x.pending[1:]
+	} else {
+		if !x.rows.Next() {
+			x.stickyErr = x.rows.Error()
+			if x.stickyErr == nil {
+				x.stickyErr = io.EOF
+			}
+			return nil, x.stickyErr
+		}
 
-	cols, err := x.rows.Columns()
-	if err != nil {
-		x.stickyErr = err
-		return nil, x.stickyErr
+		var err error
+		cols, err = x.rows.Columns()
+		if err != nil {
+			x.stickyErr = err
+			return nil, x.stickyErr
+		}
 	}
 	if len(cols) > len(x.head) {
 		cols = cols[:len(x.head)]
 	}
 
-	vals := make([][]string, len(cols))
-	for i, c := range cols {
-		vals[i] = strings.Split(c, excelMultiSplit)
-	}
-
 	return &simpleRec{
 		fields: x.head,
-		values: vals,
+		values: x.splitRow(x.head, cols),
 	}, nil
 }
 
@@ -201,3 +295,209 @@ func (x *XLSX) Next() (Record, error) {
 func (x *XLSX) Err() error {
 	return x.stickyErr
 }
+
+// Close releases the current sheet and removes any temporary file.
+func (x *XLSX) Close() error {
+	if x.rows != nil {
+		x.rows.Close()
+		x.rows = nil
+	}
+	if x.tmp == nil {
+		return nil
+	}
+	x.tmp.Close()
+	err := os.Remove(x.tmp.Name())
+	x.tmp = nil
+	return err
+}
+
+///////////
+
+// XLSXWriter serializes records to an excel document, with one sheet
+// for each table.
+type XLSXWriter struct {
+	w io.Writer
+	f *excelize.File
+
+	sheet    string
+	row      int
+	sheets   map[string]bool
+	preamble []string
+	schema   Schema
+	headed   bool
+
+	stickyErr error
+}
+
+// NewXLSXWriter returns a formats.Writer that emits an excel document.
+func NewXLSXWriter(w io.Writer) *XLSXWriter {
+	return &XLSXWriter{
+		w:      w,
+		f:      excelize.NewFile(),
+		sheets: make(map[string]bool),
+	}
+}
+
+// BeginTable starts a new sheet in the document.
+// (Implements the formats.MultiTableWriter interface)
+func (x *XLSXWriter) BeginTable(name string) error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	base := xlsxSheetName(name)
+	name = base
+	for i := 2; x.sheets[name]; i++ {
+		// sheet names must be unique
+		suffix := " (" + strconv.Itoa(i) + ")"
+		if utf8.RuneCountInString(base)+len(suffix) > xlsxMaxSheetName {
+			base = truncateRunes(base, xlsxMaxSheetName-len(suffix))
+		}
+		name = base + suffix
+	}
+
+	if len(x.sheets) == 0 {
+		// new files always contain a default sheet
+		x.f.SetSheetName(x.f.GetSheetList()[0], name)
+	} else {
+		x.f.NewSheet(name)
+	}
+	x.sheets[name] = true
+	x.sheet = name
+	x.row = 0
+	x.preamble = nil
+	x.schema = nil
+	x.headed = false
+	return nil
+}
+
+// SetSchema sets the schema of the current sheet. Single values in numeric
+// and boolean fields are written as numbers and booleans instead of text.
+// (Implements the formats.SchemaWriter interface)
+func (x *XLSXWriter) SetSchema(s Schema) {
+	if x.sheet == "" {
+		x.BeginTable("Sheet1")
+	}
+	x.schema = s
+}
+
+// SetPreamble sets the lines written above the header row of the current
+// sheet. Tabs separate the cells of each row.
+// (Implements the formats.PreambleWriter interface)
+func (x *XLSXWriter) SetPreamble(lines []string) {
+	if x.sheet == "" {
+		x.BeginTable("Sheet1")
+	}
+	x.preamble = lines
+}
+
+// Write serializes the Record.
+// (Implements the formats.Writer interface)
+func (x *XLSXWriter) Write(rec Record) error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	if x.sheet == "" {
+		x.BeginTable("Sheet1")
+	}
+	if !x.headed {
+		x.headed = true
+		for _, line := range x.preamble {
+			x.writeRow(excelCells(strings.Split(line, "\t")))
+		}
+		x.writeRow(excelCells(rec.Fields()))
+	}
+
+	line := make([]interface{}, len(rec.Fields()))
+	for i, v := range rec.Fields() {
+		line[i] = x.cellValue(rec, v)
+	}
+	x.writeRow(line)
+	return x.stickyErr
+}
+
+// cellValue returns the value of the cell for a field, as a number or
+// boolean if possible according to the schema.
+func (x *XLSXWriter) cellValue(rec Record, field string) interface{} {
+	vals := rec.Values(field)
+	if len(vals) != 1 {
+		return strings.Join(vals, excelMultiSplit)
+	}
+	v := strings.TrimSpace(vals[0])
+	switch x.schema.ValueType(field) {
+	case TypeInt:
+		if n, ok := parseInt(v); ok {
+			return n
+		}
+	case TypeFloat:
+		if f, ok := parseFloat(v); ok {
+			return f
+		}
+	case TypeBool:
+		if b, ok := parseBool(v); ok {
+			return b
+		}
+	}
+	return vals[0]
+}
+
+// excelCells converts text to the cell values of a row.
+func excelCells(text []string) []interface{} {
+	cells := make([]interface{}, len(text))
+	for i, t := range text {
+		cells[i] = t
+	}
+	return cells
+}
+
+func (x *XLSXWriter) writeRow(cells []interface{}) {
+	if x.stickyErr != nil {
+		return
+	}
+	x.row++
+	var axis string
+	axis, x.stickyErr = excelize.CoordinatesToCellName(1, x.row)
+	if x.stickyErr != nil {
+		return
+	}
+	x.stickyErr = x.f.SetSheetRow(x.sheet, axis, &cells)
+}
+
+// Close finalizes the document and writes it out.
+// (Implements the formats.Writer interface)
+func (x *XLSXWriter) Close() error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	x.stickyErr = x.f.Write(x.w)
+	return x.stickyErr
+}
+
+// Err returns the last error that occured.
+func (x *XLSXWriter) Err() error {
+	return x.stickyErr
+}
+
+// sheet names are limited to 31 characters
+const xlsxMaxSheetName = 31
+
+// xlsxSheetName removes characters that are not allowed in sheet names.
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "Sheet"
+	}
+	return truncateRunes(name, xlsxMaxSheetName)
+}
+
+// truncateRunes returns the first n characters of s.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,84 @@
+package formats
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXLSXWriterTables(t *testing.T) {
+	tables := []struct {
+		name string
+		recs []map[string]string
+	}{
+		{"Genes", []map[string]string{{"id": "ENSG01", "name": "A"}, {"id": "ENSG02", "name": "B"}}},
+		{"Proteins", []map[string]string{{"id": "P1", "name": "C"}}},
+	}
+
+	var buf bytes.Buffer
+	w := NewXLSXWriter(&buf)
+	for _, tab := range tables {
+		if err := w.BeginTable(tab.name); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range tab.recs {
+			rec := &simpleRec{}
+			rec.add("id", []string{r["id"]})
+			rec.add("name", []string{r["name"]})
+			if err := w.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := OpenXLSX(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if got := x.Tables(); !reflect.DeepEqual(got, []string{"Genes", "Proteins"}) {
+		t.Fatalf("Tables() = %q", got)
+	}
+	for _, tab := range tables {
+		if err = x.SelectTable(tab.name); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, x); !reflect.DeepEqual(got, tab.recs) {
+			t.Errorf("sheet %s = %v, want %v", tab.name, got, tab.recs)
+		}
+	}
+}
+
+func TestXLSXWriterSheetNames(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXLSXWriter(&buf)
+	long := strings.Repeat("é", 40)
+	for _, name := range []string{"a/b", "a/b", "", "Sheet1", long, long} {
+		if err := w.BeginTable(name); err != nil {
+			t.Fatal(err)
+		}
+		rec := &simpleRec{}
+		rec.add("id", []string{"1"})
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := OpenXLSX(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	want := []string{"a_b", "a_b (2)", "Sheet", "Sheet1",
+		strings.Repeat("é", 31), strings.Repeat("é", 27) + " (2)"}
+	if got := x.Tables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tables() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,514 @@
+package formats
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	xlsxWorkbookPath = "xl/workbook.xml"
+	xlsxRelsPath     = "xl/_rels/workbook.xml.rels"
+	xlsxStringsPath  = "xl/sharedStrings.xml"
+	xlsxStylesPath   = "xl/styles.xml"
+)
+
+// xlsxDateFormats maps the built-in date and time number formats to the
+// layouts excelize used for them.
+var xlsxDateFormats = map[int]string{
+	14: "01-02-06",
+	15: "2-Jan-06",
+	16: "2-Jan",
+	17: "Jan-06",
+	18: "3:04 pm",
+	19: "3:04:05 pm",
+	20: "15:04",
+	21: "15:04:05",
+	22: "1/2/06 15:04",
+	45: "04:05",
+	46: "15:04:05",
+	47: "0405.0",
+}
+
+// xlsxSheet locates a worksheet within the xlsx container.
+type xlsxSheet struct {
+	name string
+	path string
+}
+
+// xlsxWorkbook reads the sheet list, shared strings and date styles of an
+// xlsx document. Only these are held in memory, worksheets are parsed
+// incrementally by xlsxRows.
+type xlsxWorkbook struct {
+	files   map[string]*zip.File
+	sheets  []xlsxSheet
+	strings []string
+
+	// time layout for each cell style, empty if not a date
+	dates []string
+	epoch time.Time
+}
+
+func openXLSXWorkbook(zr *zip.Reader) (*xlsxWorkbook, error) {
+	wb := &xlsxWorkbook{files: make(map[string]*zip.File)}
+	for _, zf := range zr.File {
+		wb.files[zf.Name] = zf
+	}
+	if wb.files[xlsxWorkbookPath] == nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var workbook struct {
+		Props struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := wb.decode(xlsxWorkbookPath, &workbook); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if wb.files[xlsxRelsPath] != nil {
+		if err := wb.decode(xlsxRelsPath, &rels); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make(map[string]string)
+	stringsPath := xlsxStringsPath
+	stylesPath := xlsxStylesPath
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = target[1:]
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+		if strings.HasSuffix(rel.Type, "/sharedStrings") {
+			stringsPath = target
+		}
+		if strings.HasSuffix(rel.Type, "/styles") {
+			stylesPath = target
+		}
+	}
+	for i, s := range workbook.Sheets {
+		p, ok := targets[s.RID]
+		if !ok {
+			p = "xl/worksheets/sheet" + strconv.Itoa(i+1) + ".xml"
+		}
+		if wb.files[p] == nil {
+			// e.g. a chartsheet
+			continue
+		}
+		wb.sheets = append(wb.sheets, xlsxSheet{name: s.Name, path: p})
+	}
+	if len(wb.sheets) == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if wb.files[stringsPath] != nil {
+		if err := wb.readStrings(stringsPath); err != nil {
+			return nil, err
+		}
+	}
+	if wb.files[stylesPath] != nil {
+		if err := wb.readStyles(stylesPath); err != nil {
+			return nil, err
+		}
+	}
+	wb.epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if workbook.Props.Date1904 {
+		wb.epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return wb, nil
+}
+
+// decode unmarshals a (small) xml file in the container.
+func (wb *xlsxWorkbook) decode(name string, v interface{}) error {
+	rc, err := wb.files[name].Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// readStrings loads the shared strings table. Rich text runs are joined
+// and phonetic hints are ignored.
+func (wb *xlsxWorkbook) readStrings(name string) error {
+	rc, err := wb.files[name].Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	d := xml.NewDecoder(rc)
+	var sb strings.Builder
+	phonetic := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				sb.Reset()
+			case "rPh":
+				phonetic = true
+			case "t":
+				text, err := xmlText(d)
+				if err != nil {
+					return err
+				}
+				if !phonetic {
+					sb.WriteString(text)
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				wb.strings = append(wb.strings, sb.String())
+			case "rPh":
+				phonetic = false
+			}
+		}
+	}
+}
+
+// readStyles finds the cell styles with a date or time number format.
+func (wb *xlsxWorkbook) readStyles(name string) error {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Xfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := wb.decode(name, &styles); err != nil {
+		return err
+	}
+	custom := make(map[int]string)
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = xlsxDateLayout(f.Code)
+	}
+	wb.dates = make([]string, len(styles.Xfs))
+	for i, xf := range styles.Xfs {
+		if layout, ok := custom[xf.NumFmtID]; ok {
+			wb.dates[i] = layout
+		} else {
+			wb.dates[i] = xlsxDateFormats[xf.NumFmtID]
+		}
+	}
+	return nil
+}
+
+// xlsxDateLayout returns an ISO 8601 time layout for a custom number format
+// that shows a date or time, or an empty string for other formats.
+func xlsxDateLayout(code string) string {
+	var date, clock bool
+	quoted := false
+	bracket := false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quoted:
+			quoted = c != '"'
+		case bracket:
+			// elapsed time like [h] is a time, colors and locales are not
+			bracket = c != ']'
+			if c == 'h' || c == 'H' || c == 's' || c == 'S' {
+				clock = true
+			}
+		case c == '"':
+			quoted = true
+		case c == '[':
+			bracket = true
+		case c == '\\' || c == '_' || c == '*':
+			// the next character is a literal
+			i++
+		case c == 'y' || c == 'Y' || c == 'd' || c == 'D':
+			date = true
+		case c == 'h' || c == 'H' || c == 's' || c == 'S':
+			clock = true
+		}
+	}
+	switch {
+	case date && clock:
+		return "2006-01-02 15:04:05"
+	case date:
+		return "2006-01-02"
+	case clock:
+		return "15:04:05"
+	}
+	return ""
+}
+
+// rows starts reading the numbered sheet.
+func (wb *xlsxWorkbook) rows(sheet int) (*xlsxRows, error) {
+	rc, err := wb.files[wb.sheets[sheet].path].Open()
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxRows{
+		rc:      rc,
+		d:       xml.NewDecoder(rc),
+		strings: wb.strings,
+		dates:   wb.dates,
+		epoch:   wb.epoch,
+	}, nil
+}
+
+// xlsxRows parses the rows of a worksheet as they are read. Rows missing
+// from the sheet are returned as empty rows so that row indexes match the
+// row numbers of the sheet.
+type xlsxRows struct {
+	rc      io.ReadCloser
+	d       *xml.Decoder
+	strings []string
+	dates   []string
+	epoch   time.Time
+
+	// number of the current row, and the row read ahead past a gap
+	num     int
+	next    []string
+	nextNum int
+
+	cols []string
+	err  error
+}
+
+// Next moves to the next row in the sheet, returning false at the end of
+// the sheet or if an error occurred.
+func (r *xlsxRows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.nextNum == 0 {
+		if !r.readAhead() {
+			return false
+		}
+	}
+	r.num++
+	if r.num < r.nextNum {
+		r.cols = nil
+		return true
+	}
+	r.cols, r.next, r.nextNum = r.next, nil, 0
+	return true
+}
+
+// readAhead reads the next row element of the sheet.
+func (r *xlsxRows) readAhead() bool {
+	for {
+		tok, err := r.d.Token()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return false
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "row" {
+			continue
+		}
+		r.nextNum = r.num + 1
+		for _, a := range se.Attr {
+			if a.Name.Local != "r" {
+				continue
+			}
+			if n, err := strconv.Atoi(a.Value); err == nil && n > r.num {
+				r.nextNum = n
+			}
+		}
+		if r.nextNum > sheetMaxRows {
+			r.err = errSheetLimit
+			return false
+		}
+		r.next, r.err = r.readRow()
+		return r.err == nil
+	}
+}
+
+// Columns returns the cell values of the current row.
+func (r *xlsxRows) Columns() ([]string, error) {
+	return r.cols, r.err
+}
+
+// Error returns the error that stopped iteration, if any.
+func (r *xlsxRows) Error() error {
+	return r.err
+}
+
+// Close releases the sheet.
+func (r *xlsxRows) Close() error {
+	return r.rc.Close()
+}
+
+// readRow reads the cells up to the end of the current row element.
+func (r *xlsxRows) readRow() ([]string, error) {
+	var cols []string
+	for {
+		tok, err := r.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			col := len(cols)
+			var typ string
+			style := 0
+			for _, a := range t.Attr {
+				switch a.Name.Local {
+				case "r":
+					if c, ok := xlsxColumn(a.Value); ok {
+						col = c
+					}
+				case "t":
+					typ = a.Value
+				case "s":
+					style, _ = strconv.Atoi(a.Value)
+				}
+			}
+			if col >= sheetMaxCols {
+				return nil, errSheetLimit
+			}
+			v, err := r.readCell(typ, style)
+			if err != nil {
+				return nil, err
+			}
+			for len(cols) < col {
+				cols = append(cols, "")
+			}
+			if col < len(cols) {
+				cols[col] = v
+			} else {
+				cols = append(cols, v)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return cols, nil
+			}
+		}
+	}
+}
+
+// readCell reads the value up to the end of the current cell element.
+// Numbers in a date style are formatted as dates.
+func (r *xlsxRows) readCell(typ string, style int) (string, error) {
+	var v string
+	for {
+		tok, err := r.d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "v":
+				if v, err = xmlText(r.d); err != nil {
+					return "", err
+				}
+			case t.Name.Local == "t" && typ == "inlineStr":
+				text, err := xmlText(r.d)
+				if err != nil {
+					return "", err
+				}
+				v += text
+			}
+		case xml.EndElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			if typ == "s" {
+				i, err := strconv.Atoi(v)
+				if err != nil || i < 0 || i >= len(r.strings) {
+					return "", ErrUnsupportedFormat
+				}
+				v = r.strings[i]
+			}
+			if (typ == "" || typ == "n") && style > 0 && style < len(r.dates) && r.dates[style] != "" {
+				v = r.formatDate(v, r.dates[style])
+			}
+			return v, nil
+		}
+	}
+}
+
+// formatDate formats a serial date number with the layout, leaving values
+// that aren't numbers as they are.
+func (r *xlsxRows) formatDate(v, layout string) string {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return v
+	}
+	days := math.Floor(f)
+	ms := math.Round((f - days) * 24 * 60 * 60 * 1000)
+	t := r.epoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+	return t.Format(layout)
+}
+
+// xmlText returns the character data up to the end of the current element.
+func xmlText(d *xml.Decoder) (string, error) {
+	var sb strings.Builder
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return sb.String(), nil
+			}
+			depth--
+		}
+	}
+}
+
+// xlsxColumn returns the 0-based column index of a cell reference like "AB12".
+// Columns past the sheet size limits are returned as sheetMaxCols.
+func xlsxColumn(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A') + 1
+		n++
+		if col > sheetMaxCols {
+			return sheetMaxCols, true
+		}
+	}
+	return col - 1, n > 0
+}
@@ -0,0 +1,149 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildXLSX returns a minimal workbook with one sheet.
+func buildXLSX(t *testing.T, sheet, sharedStrings, styles string) string {
+	t.Helper()
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"></Types>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Genes" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/sharedStrings" Target="sharedStrings.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+		{"xl/worksheets/sheet1.xml", sheet},
+		{"xl/sharedStrings.xml", sharedStrings},
+		{"xl/styles.xml", styles},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.WriteString(w, f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestXLSXStream(t *testing.T) {
+	content := buildXLSX(t, `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c></row>
+<row r="3"><c r="A3" t="s"><v>1</v></c><c r="B3" t="s"><v>2</v></c><c r="C3" t="s"><v>3</v></c><c r="D3" t="s"><v>4</v></c></row>
+<row r="4"><c r="A4" t="s"><v>5</v></c><c r="B4" t="inlineStr"><is><t>ENSG01</t></is></c><c r="C4" s="1"><v>43831</v></c><c r="D4" s="3"><v>1.5</v></c></row>
+<row r="6"><c r="A6" t="s"><v>6</v></c><c r="C6" s="2"><v>43832.5</v></c><c r="D6"><v>2</v></c></row>
+</sheetData></worksheet>`, `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Gene list</t></si><si><t>name</t></si><si><t>id</t></si><si><t>date</t></si><si><r><t>sc</t></r><r><t>ore</t></r></si><si><t>A</t></si><si><t>B</t></si>
+</sst>`, `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd\ hh:mm;@"/><numFmt numFmtId="165" formatCode="[Red]0.00"/></numFmts>
+<cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/><xf numFmtId="165"/></cellXfs>
+</styleSheet>`)
+
+	r := openString(t, ".xlsx", content)
+	hr := r.(HeaderReader)
+	if got := hr.HeaderRow(); got != 2 {
+		t.Errorf("HeaderRow() = %d, want 2", got)
+	}
+	if err := hr.SetHeaderRow(2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"A", "ENSG01", "01-01-20", "1.5"},
+		{"", "", "", ""},
+		{"B", "", "2020-01-02 12:00:00", "2"},
+	}
+	var got [][]string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := rec.Fields()
+		if !reflect.DeepEqual(fields, []string{"name", "id", "date", "score"}) {
+			t.Fatalf("fields = %q", fields)
+		}
+		var row []string
+		for _, f := range fields {
+			row = append(row, strings.Join(rec.Values(f), "|"))
+		}
+		got = append(got, row)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+func TestXLSXDateLayout(t *testing.T) {
+	for code, want := range map[string]string{
+		"General":              "",
+		"0.00":                 "",
+		"[Red]#,##0":           "",
+		`"days"\ 0`:            "",
+		"yyyy-mm-dd":           "2006-01-02",
+		"d/m/yy":               "2006-01-02",
+		"[h]:mm:ss":            "15:04:05",
+		"hh:mm AM/PM":          "15:04:05",
+		"[$-409]mmm d, yyyy h": "2006-01-02 15:04:05",
+	} {
+		if got := xlsxDateLayout(code); got != want {
+			t.Errorf("xlsxDateLayout(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestXLSXSheetLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name, row string
+		err       error
+	}{
+		{"last cell", `<row r="1048576"><c r="XFD1048576" t="inlineStr"><is><t>x</t></is></c></row>`, nil},
+		{"overflowing column", `<row r="2"><c r="ZZZZZZZZZZZZZZA2"><v>1</v></c></row>`, errSheetLimit},
+		{"wide column", `<row r="2"><c r="ZZZZZZ2"><v>1</v></c></row>`, errSheetLimit},
+		{"column past XFD", `<row r="2"><c r="XFE2"><v>1</v></c></row>`, errSheetLimit},
+		{"row past limit", `<row r="2000000000"><c r="A2000000000"><v>1</v></c></row>`, errSheetLimit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content := buildXLSX(t, `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="inlineStr"><is><t>id</t></is></c></row>`+tc.row+`
+</sheetData></worksheet>`, `<sst/>`, `<styleSheet/>`)
+
+			x, err := OpenXLSX(strings.NewReader(content))
+			for err == nil {
+				_, err = x.Next()
+			}
+			if tc.err == nil && err != io.EOF {
+				t.Errorf("got %v, want io.EOF", err)
+			}
+			if tc.err != nil && err != tc.err {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -14,6 +16,9 @@ var (
 
 	// ErrWriterNotSupported is returned when Writer is not implemented for a Format.
 	ErrWriterNotSupported = errors.New("databio/formats: Writer not supported for this format")
+
+	// ErrUnknownTable is returned when selecting a table that is not in the document.
+	ErrUnknownTable = errors.New("databio/formats: table not found")
 )
 
 // Open returns a Reader for the input file if it detects that it
@@ -33,6 +38,18 @@ func Open(in *os.File) (Reader, error) {
 		return OpenTSV(in)
 	case ".xlsx":
 		return OpenXLSX(in)
+	case ".xls":
+		return OpenXLS(in)
+	case ".ods":
+		return OpenODS(in)
+	case ".json":
+		return OpenJSON(in)
+	case ".ndjson", ".jsonl":
+		return OpenNDJSON(in)
+	case ".parquet":
+		return OpenParquet(in)
+	case ".zip":
+		return OpenZIP(in)
 	}
 	return nil, ErrUnsupportedFormat
 }
@@ -46,15 +63,49 @@ type Reader interface {
 	Err() error
 }
 
+// MultiTableReader is a Reader for documents that contain multiple tables of
+// records, such as the sheets of a spreadsheet or the files in an archive.
+// Next returns the Records of the current table.
+type MultiTableReader interface {
+	Reader
+
+	// Tables returns the names of all tables in the document.
+	Tables() []string
+
+	// Table returns the name of the current table.
+	Table() string
+
+	// SelectTable moves to the start of the named table.
+	SelectTable(name string) error
+
+	// NextTable moves to the start of the next table in the document.
+	// Returns io.EOF if there are no more tables.
+	NextTable() error
+}
+
 // Writer serializes records to a supported Format.
 type Writer interface {
 	// Write serializes the Record.
 	Write(Record) error
 
+	// Close finalizes the document and flushes any buffered data.
+	// It does not close the underlying io.Writer.
+	Close() error
+
 	// Err returns the last error that occured.
 	Err() error
 }
 
+// MultiTableWriter is a Writer for documents that contain multiple tables of
+// records, such as the sheets of a spreadsheet.
+type MultiTableWriter interface {
+	Writer
+
+	// BeginTable starts a new named table in the document. Subsequent
+	// Records are written to the new table.
+	BeginTable(name string) error
+}
+
 // Record represents a single record sourced from the Format.
 type Record interface {
 	// Each iterates over every field/value pair in the Record.
@@ -68,6 +119,22 @@ type Record interface {
 
 	// Set the values for a named Field in the Record.
 	Set(field string, values []string)
+
+	// Ints returns the non-blank values of a named Field as integers.
+	// Returns ErrValueType if a value is not an integer.
+	Ints(field string) ([]int64, error)
+
+	// Floats returns the non-blank values of a named Field as numbers.
+	// Returns ErrValueType if a value is not a number.
+	Floats(field string) ([]float64, error)
+
+	// Bools returns the non-blank values of a named Field as booleans.
+	// Returns ErrValueType if a value is not a boolean.
+	Bools(field string) ([]bool, error)
+
+	// Dates returns the non-blank values of a named Field as dates and
+	// times. Returns ErrValueType if a value is not a date.
+	Dates(field string) ([]time.Time, error)
 }
 
 type simpleRec struct {
@@ -105,6 +172,18 @@ func (x *simpleRec) Values(field string) []string {
 	return nil
 }
 
+// add appends values to a named Field, adding the Field if necessary.
+func (x *simpleRec) add(field string, vals []string) {
+	for i, f := range x.fields {
+		if f == field {
+			x.values[i] = append(x.values[i], vals...)
+			return
+		}
+	}
+	x.fields = append(x.fields, field)
+	x.values = append(x.values, vals)
+}
+
 func (x *simpleRec) Set(field string, vals []string) {
 	for i, f := range x.fields {
 		if f == field {
@@ -116,6 +195,69 @@ func (x *simpleRec) Set(field string, vals []string) {
 	x.values = append(x.values, vals)
 }
 
+func (x *simpleRec) Ints(field string) ([]int64, error) {
+	var res []int64
+	for _, v := range x.Values(field) {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, ErrValueType
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func (x *simpleRec) Floats(field string) ([]float64, error) {
+	var res []float64
+	for _, v := range x.Values(field) {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, ErrValueType
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+func (x *simpleRec) Bools(field string) ([]bool, error) {
+	var res []bool
+	for _, v := range x.Values(field) {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		b, ok := parseBool(v)
+		if !ok {
+			var err error
+			if b, err = strconv.ParseBool(v); err != nil {
+				return nil, ErrValueType
+			}
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+func (x *simpleRec) Dates(field string) ([]time.Time, error) {
+	var res []time.Time
+	for _, v := range x.Values(field) {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		t, ok := parseDate(v)
+		if !ok {
+			return nil, ErrValueType
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
 ///////////
 
 // Format describes a supported data interchange protocol.
@@ -163,6 +305,26 @@ func Register(f *Format) int {
 	return len(supportedFormats)
 }
 
+// Lookup returns the registered Format with the given name or file
+// extension (case-insensitive, with or without the "." dot prefix).
+// Returns ErrUnsupportedFormat if no such Format is registered.
+func Lookup(name string) (*Format, error) {
+	name = strings.ToLower(name)
+	for _, f := range supportedFormats {
+		if strings.ToLower(f.Name) == name {
+			return f, nil
+		}
+	}
+	for _, f := range supportedFormats {
+		for _, ext := range f.Extensions {
+			if ext == name || ext[1:] == name {
+				return f, nil
+			}
+		}
+	}
+	return nil, ErrUnsupportedFormat
+}
+
 func writerNotSupported(w io.Writer) (Writer, error) {
 	return nil, ErrWriterNotSupported
 }
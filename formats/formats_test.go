@@ -0,0 +1,31 @@
+package formats
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// openString opens the content as a document with the given extension.
+func openString(t *testing.T, ext, content string) Reader {
+	t.Helper()
+	f, err := ioutil.TempFile("", "databio-test-*"+ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
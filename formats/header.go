@@ -0,0 +1,229 @@
+package formats
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// number of rows after a candidate header used to describe the body
+	headerBodyRows = 100
+
+	// candidate header rows scoring at or below this look like data
+	headerMinScore = 0.5
+)
+
+// ErrHeaderRow is returned for a header row index less than -1.
+var ErrHeaderRow = errors.New("databio/formats: invalid header row")
+
+// HeaderReader is a Reader for tabular documents where the header row is
+// detected, and can be overridden.
+type HeaderReader interface {
+	Reader
+
+	// HeaderRow returns the index of the row used for field names, counting
+	// from 0 at the first row of the table, or -1 if the table has no header
+	// and field names were generated.
+	HeaderRow() int
+
+	// HeaderConfidence returns a score from 0 to 1 indicating how confident
+	// the detection of the header row was.
+	HeaderConfidence() float64
+
+	// SetHeaderRow moves to the start of the table and uses the indexed row
+	// for field names, or generates field names if row is -1. Subsequent
+	// Records start on the row after the header. Returns ErrHeaderRow if
+	// row is less than -1, or io.EOF if the table has no such row.
+	SetHeaderRow(row int) error
+}
+
+// headerInfo implements the accessors of the HeaderReader interface.
+type headerInfo struct {
+	headRow        int
+	headConfidence float64
+
+	// lines above the header row
+	preamble []string
+}
+
+// HeaderRow returns the index of the row used for field names.
+// (Implements the formats.HeaderReader interface)
+func (h *headerInfo) HeaderRow() int {
+	return h.headRow
+}
+
+// HeaderConfidence returns the confidence of the header row detection.
+// (Implements the formats.HeaderReader interface)
+func (h *headerInfo) HeaderConfidence() float64 {
+	return h.headConfidence
+}
+
+// findHeader chooses the header row from the first rows of a table.
+//
+// Only rows with the most frequent number of columns are candidates. Each
+// candidate is scored by how different its values are from the rows that
+// follow it, e.g. text over a numeric column. Descriptive lines above the
+// header score highly too, so the last high-scoring candidate before the
+// first data-like row is chosen. Returns row -1 if the first candidate
+// looks like data.
+func findHeader(rows [][]string) (row int, confidence float64) {
+	// out of the first N rows, which number of columns is the most frequent?
+	bestcols := 0
+	colcounts := make(map[int]int)
+	for _, r := range rows {
+		colcounts[len(r)]++
+		if colcounts[len(r)] > colcounts[bestcols] {
+			bestcols = len(r)
+		}
+	}
+
+	var candidates []int
+	for i, r := range rows {
+		if len(r) == bestcols {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return -1, 0
+	}
+
+	row, confidence = -1, 0
+	for k, i := range candidates {
+		body := make([][]string, 0, headerBodyRows)
+		for _, j := range candidates[k+1:] {
+			if len(body) == headerBodyRows {
+				break
+			}
+			body = append(body, rows[j])
+		}
+		if len(body) == 0 && k > 0 {
+			break
+		}
+		score := headerScore(rows[i], body)
+		if score <= headerMinScore {
+			if row == -1 {
+				confidence = 1 - score
+			}
+			break
+		}
+		row, confidence = i, score
+	}
+	return row, confidence
+}
+
+// headerScore returns the mean score from 0 (data-like) to 1 (header-like)
+// for each value of the candidate row versus the body rows below it.
+func headerScore(candidate []string, body [][]string) float64 {
+	if len(candidate) == 0 {
+		return 0
+	}
+	if len(body) == 0 {
+		// nothing to compare to, so assume a header as usual
+		return 1
+	}
+
+	total := 0.0
+	seen := make(map[string]bool)
+	for j, v := range candidate {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if seen[v] {
+			// field names are usually unique
+			continue
+		}
+		seen[v] = true
+
+		numeric, values := 0, 0
+		shapes := make(map[string]bool)
+		found := false
+		for _, r := range body {
+			if j >= len(r) || strings.TrimSpace(r[j]) == "" {
+				continue
+			}
+			b := strings.TrimSpace(r[j])
+			values++
+			if isNumeric(b) {
+				numeric++
+			}
+			shapes[valueShape(b)] = true
+			found = found || b == v
+		}
+
+		switch {
+		case values == 0:
+			total += 0.5
+		case found:
+			// the same value appears in the data
+		case numeric*2 > values:
+			if !isNumeric(v) {
+				total++
+			}
+		case !shapes[valueShape(v)]:
+			total += 0.75
+		default:
+			total += 0.25
+		}
+	}
+	return total / float64(len(candidate))
+}
+
+func isNumeric(v string) bool {
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+// valueShape summarizes the classes of characters present in a value, e.g.
+// "TP53" => "A9", "tumor protein p53" => "a9 ", "GeneID" => "Aa".
+func valueShape(v string) string {
+	var upper, lower, digit, space, other bool
+	for _, c := range v {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsSpace(c):
+			space = true
+		default:
+			other = true
+		}
+	}
+	var sb strings.Builder
+	for i, present := range []bool{upper, lower, digit, space, other} {
+		if present {
+			sb.WriteByte("Aa9 ."[i])
+		}
+	}
+	return sb.String()
+}
+
+// columnName returns the spreadsheet-style name of a 0-based column
+// index, e.g. "Column A", "Column Z", "Column AA".
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return "Column " + name
+}
+
+// generatedHeader returns field names for a table without a header.
+func generatedHeader(rows [][]string) []string {
+	n := 0
+	for _, r := range rows {
+		if len(r) > n {
+			n = len(r)
+		}
+	}
+	head := make([]string, n)
+	for i := range head {
+		head[i] = columnName(i)
+	}
+	return head
+}
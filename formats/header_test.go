@@ -0,0 +1,136 @@
+package formats
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderDetection(t *testing.T) {
+	for _, tc := range []struct {
+		name, ext, content string
+		row                int
+		fields             []string
+		preamble           []string
+	}{
+		{"csv title", ".csv", "Exported genes\nname,id,score\nA,ENSG01,1\nB,ENSG02,2\nC,ENSG03,3\n",
+			1, []string{"name", "id", "score"}, []string{"Exported genes"}},
+		{"csv headerless", ".csv", "1,ENSG01,1\n2,ENSG02,2\n3,ENSG03,3\n",
+			-1, []string{"Column A", "Column B", "Column C"}, nil},
+		{"tsv title", ".tsv", "Exported genes\nname\tid\tscore\nA\tENSG01\t1\nB\tENSG02\t2\nC\tENSG03\t3\n",
+			1, []string{"name", "id", "score"}, []string{"Exported genes"}},
+		{"tsv headerless", ".tsv", "1\tENSG01\t1\n2\tENSG02\t2\n3\tENSG03\t3\n",
+			-1, []string{"Column A", "Column B", "Column C"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := openString(t, tc.ext, tc.content)
+			hr := r.(HeaderReader)
+			if got := hr.HeaderRow(); got != tc.row {
+				t.Errorf("HeaderRow() = %d, want %d", got, tc.row)
+			}
+			rec, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rec.Fields(), tc.fields) {
+				t.Errorf("fields = %q, want %q", rec.Fields(), tc.fields)
+			}
+			if got := r.(PreambleReader).Preamble(); len(got)+len(tc.preamble) > 0 && !reflect.DeepEqual(got, tc.preamble) {
+				t.Errorf("preamble = %q, want %q", got, tc.preamble)
+			}
+		})
+	}
+}
+
+func TestSetHeaderRow(t *testing.T) {
+	for _, ext := range []string{".csv", ".tsv"} {
+		t.Run(ext, func(t *testing.T) {
+			content := "name,id\nA,1\nB,2\nC,3\n"
+			if ext == ".tsv" {
+				content = "name\tid\nA\t1\nB\t2\nC\t3\n"
+			}
+			r := openString(t, ext, content)
+			hr := r.(HeaderReader)
+
+			if err := hr.SetHeaderRow(-2); err != ErrHeaderRow {
+				t.Errorf("SetHeaderRow(-2) = %v, want ErrHeaderRow", err)
+			}
+			if err := hr.SetHeaderRow(10); err != io.EOF {
+				t.Errorf("SetHeaderRow(10) = %v, want io.EOF", err)
+			}
+
+			// the override can be changed after an error
+			if err := hr.SetHeaderRow(-1); err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for {
+				rec, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n == 0 && rec.Values("Column A")[0] != "name" {
+					t.Errorf("first headerless record = %q", rec.Values("Column A"))
+				}
+				n++
+			}
+			if n != 4 {
+				t.Errorf("read %d headerless records, want 4", n)
+			}
+
+			if err := hr.SetHeaderRow(2); err != nil {
+				t.Fatal(err)
+			}
+			rec, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Values("B"); len(got) != 1 || got[0] != "C" {
+				t.Errorf("record after row 2 = %q, want C", got)
+			}
+		})
+	}
+}
+
+func TestSheetGridSetHeaderRow(t *testing.T) {
+	rows := [][]string{{"name", "id"}, {"A", "1"}, {"B", "2"}}
+	x := &sheetGrid{
+		sheets: []string{"Sheet1"},
+		load: func(int) ([][]string, error) {
+			return rows, nil
+		},
+	}
+	x.selectSheet(0)
+	if x.HeaderRow() != 0 {
+		t.Fatalf("HeaderRow() = %d, want 0", x.HeaderRow())
+	}
+	for {
+		if _, err := x.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := x.SetHeaderRow(-2); err != ErrHeaderRow {
+		t.Errorf("SetHeaderRow(-2) = %v, want ErrHeaderRow", err)
+	}
+	if err := x.SetHeaderRow(3); err != io.EOF {
+		t.Errorf("SetHeaderRow(3) = %v, want io.EOF", err)
+	}
+
+	// retrying without a header after reading to the end, as detection does
+	if err := x.SetHeaderRow(-1); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := x.Next()
+	if err != nil {
+		t.Fatalf("Next() after SetHeaderRow(-1) = %v", err)
+	}
+	if got := rec.Values("Column A"); len(got) != 1 || got[0] != "name" {
+		t.Errorf("first headerless record = %q, want name", got)
+	}
+}
@@ -0,0 +1,519 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	// maximum length of a single NDJSON line
+	ndjsonMaxLineSize = 16 << 20
+
+	// separator used to flatten nested object paths into field names
+	jsonPathSep = "."
+)
+
+var (
+	_ = Register(&Format{
+		Name:        "JSON",
+		Description: "JavaScript Object Notation (array of objects)",
+		Extensions:  []string{".json"},
+		MediaTypes:  []string{"application/json"},
+		Detect:      detectJSON,
+		NewReader: func(r io.Reader) (Reader, error) {
+			return OpenJSON(r)
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return NewJSONWriter(w), nil
+		},
+	})
+
+	_ = Register(&Format{
+		Name:        "NDJSON",
+		Description: "Newline-delimited JSON",
+		Extensions:  []string{".ndjson", ".jsonl"},
+		MediaTypes:  []string{"application/x-ndjson", "application/jsonl"},
+		Detect:      detectNDJSON,
+		NewReader: func(r io.Reader) (Reader, error) {
+			return OpenNDJSON(r)
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return NewNDJSONWriter(w), nil
+		},
+	})
+
+	errJSONNotObject = errors.New("databio/formats: JSON record is not an object")
+)
+
+func detectJSON(data []byte, incomplete bool) (supported, more bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return false, incomplete
+	}
+	if data[0] == '{' {
+		// a single object is a data set with one record
+		if incomplete {
+			return false, true
+		}
+		return json.Valid(data), false
+	}
+	if data[0] != '[' {
+		return false, false
+	}
+	rest := bytes.TrimSpace(data[1:])
+	if len(rest) == 0 {
+		return false, incomplete
+	}
+	if rest[0] != '{' {
+		// an array of scalars has no fields to work with
+		return false, false
+	}
+	if incomplete {
+		return true, false
+	}
+	return json.Valid(data), false
+}
+
+func detectNDJSON(data []byte, incomplete bool) (supported, more bool) {
+	if incomplete {
+		idx := bytes.LastIndexByte(data, '\n')
+		if idx == -1 {
+			// not even a full line, need more data
+			return false, true
+		}
+		data = data[:idx]
+	}
+
+	nlines := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '{' || !json.Valid(line) {
+			return false, false
+		}
+		nlines++
+	}
+
+	if nlines >= 2 {
+		return true, false
+	}
+	// a single line could just as well be a plain JSON document
+	return false, incomplete
+}
+
+// JSON supports reading records from a JSON array of objects, or from
+// newline-delimited JSON (one object per line).
+//
+// Nested object paths are flattened into dotted field names (e.g. a field
+// "gene" within an object "xref" is named "xref.gene"), and JSON arrays
+// become multi-valued fields.
+type JSON struct {
+	dec    *json.Decoder
+	s      *bufio.Scanner
+	single *jsonObject
+
+	stickyErr error
+}
+
+// OpenJSON opens a JSON document and returns a formats.Reader.
+// The document must be either an array of objects or a single object.
+func OpenJSON(in io.Reader) (*JSON, error) {
+	x := &JSON{
+		dec: json.NewDecoder(in),
+	}
+	x.dec.UseNumber()
+
+	tok, err := x.dec.Token()
+	if err != nil {
+		x.stickyErr = err
+		return x, x.stickyErr
+	}
+	switch tok {
+	case json.Delim('['):
+		// iterate over the array elements
+	case json.Delim('{'):
+		// a single object is a data set with one record
+		x.single, x.stickyErr = readJSONObject(x.dec)
+		x.dec = nil
+	default:
+		x.stickyErr = ErrUnsupportedFormat
+	}
+	return x, x.stickyErr
+}
+
+// OpenNDJSON opens a newline-delimited JSON document and returns a
+// formats.Reader.
+func OpenNDJSON(in io.Reader) (*JSON, error) {
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 64*1024), ndjsonMaxLineSize)
+	return &JSON{s: s}, nil
+}
+
+// Next returns the next Record in the document.
+// (Implements the formats.Reader interface)
+func (x *JSON) Next() (Record, error) {
+	if x.stickyErr != nil {
+		return nil, x.stickyErr
+	}
+
+	var v interface{}
+	var err error
+	switch {
+	case x.single != nil:
+		v = x.single
+		x.single = nil
+		x.stickyErr = io.EOF
+
+	case x.s != nil:
+		line := ""
+		for line == "" {
+			if !x.s.Scan() {
+				x.stickyErr = x.s.Err()
+				if x.stickyErr == nil {
+					x.stickyErr = io.EOF
+				}
+				return nil, x.stickyErr
+			}
+			line = strings.TrimSpace(x.s.Text())
+		}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		v, err = readJSONValue(dec)
+
+	case x.dec != nil && x.dec.More():
+		v, err = readJSONValue(x.dec)
+
+	default:
+		x.stickyErr = io.EOF
+		return nil, x.stickyErr
+	}
+	if err != nil {
+		x.stickyErr = err
+		return nil, x.stickyErr
+	}
+
+	obj, ok := v.(*jsonObject)
+	if !ok {
+		x.stickyErr = errJSONNotObject
+		return nil, x.stickyErr
+	}
+	rec := &jsonRec{obj: obj}
+	flattenJSON(&rec.simpleRec, "", obj)
+	return rec, nil
+}
+
+// Err returns the last error that occured.
+func (x *JSON) Err() error {
+	return x.stickyErr
+}
+
+///////////
+
+// JSONWriter serializes records as a JSON array of objects, or as
+// newline-delimited JSON.
+//
+// Records read from a JSON document are written in their original
+// structure, including any changes made using Set. Other records are
+// expanded from their dotted field names into nested objects.
+type JSONWriter struct {
+	w     *bufio.Writer
+	lines bool
+	n     int
+
+	stickyErr error
+}
+
+// NewJSONWriter returns a formats.Writer that emits a JSON array of objects.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: bufio.NewWriter(w)}
+}
+
+// NewNDJSONWriter returns a formats.Writer that emits newline-delimited JSON.
+func NewNDJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: bufio.NewWriter(w), lines: true}
+}
+
+// Write serializes the Record.
+// (Implements the formats.Writer interface)
+func (x *JSONWriter) Write(rec Record) error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+
+	var obj *jsonObject
+	if jr, ok := rec.(*jsonRec); ok {
+		obj = jr.obj
+	} else {
+		obj = newJSONObject()
+		for _, field := range rec.Fields() {
+			setJSONPath(obj, strings.Split(field, jsonPathSep), rec.Values(field))
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if !x.lines {
+		if x.n == 0 {
+			buf.WriteString("[\n")
+		} else {
+			buf.WriteString(",\n")
+		}
+	}
+	x.stickyErr = writeJSONValue(buf, obj)
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	if x.lines {
+		buf.WriteByte('\n')
+	}
+	_, x.stickyErr = x.w.Write(buf.Bytes())
+	x.n++
+	return x.stickyErr
+}
+
+// Close finalizes the document and flushes any buffered data.
+// (Implements the formats.Writer interface)
+func (x *JSONWriter) Close() error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	if !x.lines {
+		if x.n == 0 {
+			_, x.stickyErr = x.w.WriteString("[]\n")
+		} else {
+			_, x.stickyErr = x.w.WriteString("\n]\n")
+		}
+		if x.stickyErr != nil {
+			return x.stickyErr
+		}
+	}
+	x.stickyErr = x.w.Flush()
+	return x.stickyErr
+}
+
+// Err returns the last error that occured.
+func (x *JSONWriter) Err() error {
+	return x.stickyErr
+}
+
+///////////
+
+// jsonRec is a Record that keeps the original JSON object, so that
+// changes can be written back out in the same structure.
+type jsonRec struct {
+	simpleRec
+
+	obj *jsonObject
+}
+
+func (x *jsonRec) Set(field string, vals []string) {
+	x.simpleRec.Set(field, vals)
+	setJSONPath(x.obj, strings.Split(field, jsonPathSep), vals)
+}
+
+// flattenJSON adds the values within v to the record using dotted field names.
+func flattenJSON(rec *simpleRec, prefix string, v interface{}) {
+	switch t := v.(type) {
+	case *jsonObject:
+		for _, key := range t.keys {
+			field := key
+			if prefix != "" {
+				field = prefix + jsonPathSep + key
+			}
+			flattenJSON(rec, field, t.vals[key])
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			rec.add(prefix, nil)
+		}
+		for _, elem := range t {
+			flattenJSON(rec, prefix, elem)
+		}
+	case nil:
+		rec.add(prefix, nil)
+	case string:
+		rec.add(prefix, []string{t})
+	case json.Number:
+		rec.add(prefix, []string{t.String()})
+	case bool:
+		if t {
+			rec.add(prefix, []string{"true"})
+		} else {
+			rec.add(prefix, []string{"false"})
+		}
+	}
+}
+
+// setJSONPath sets the value at the given object path, creating any
+// intermediate objects as needed. The existing value's shape is retained
+// where possible, i.e. a single value replacing a scalar stays a scalar.
+func setJSONPath(obj *jsonObject, path []string, vals []string) {
+	key := path[0]
+	cur := obj.vals[key]
+	if len(path) == 1 {
+		obj.set(key, jsonValues(cur, vals))
+		return
+	}
+
+	switch c := cur.(type) {
+	case *jsonObject:
+		setJSONPath(c, path[1:], vals)
+
+	case []interface{}:
+		var objs []*jsonObject
+		for _, elem := range c {
+			if o, ok := elem.(*jsonObject); ok {
+				objs = append(objs, o)
+			}
+		}
+		if len(objs) == len(vals) {
+			// one value per element, e.g. after an in-place translation
+			for i, o := range objs {
+				setJSONPath(o, path[1:], vals[i:i+1])
+			}
+			return
+		}
+		if len(objs) > 0 {
+			for _, o := range objs {
+				setJSONPath(o, path[1:], vals)
+			}
+			return
+		}
+		child := newJSONObject()
+		obj.set(key, child)
+		setJSONPath(child, path[1:], vals)
+
+	default:
+		child := newJSONObject()
+		obj.set(key, child)
+		setJSONPath(child, path[1:], vals)
+	}
+}
+
+func jsonValues(orig interface{}, vals []string) interface{} {
+	if _, isList := orig.([]interface{}); !isList {
+		switch len(vals) {
+		case 0:
+			return nil
+		case 1:
+			return vals[0]
+		}
+	}
+	res := make([]interface{}, len(vals))
+	for i, v := range vals {
+		res[i] = v
+	}
+	return res
+}
+
+// jsonObject is a JSON object that remembers the order of its keys.
+type jsonObject struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{vals: make(map[string]interface{})}
+}
+
+func (o *jsonObject) set(key string, v interface{}) {
+	if _, ok := o.vals[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.vals[key] = v
+}
+
+// readJSONValue decodes the next value from dec, retaining the key order of
+// any objects. Numbers are returned as json.Number (see Decoder.UseNumber).
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		return readJSONObject(dec)
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// readJSONObject decodes the remainder of an object after its opening brace.
+func readJSONObject(dec *json.Decoder) (*jsonObject, error) {
+	obj := newJSONObject()
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, errJSONNotObject
+		}
+		v, err := readJSONValue(dec)
+		if err != nil {
+			return nil, err
+		}
+		obj.set(key, v)
+	}
+	_, err := dec.Token()
+	return obj, err
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case *jsonObject:
+		buf.WriteByte('{')
+		for i, key := range t.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSONValue(buf, t.vals[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode always appends a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
@@ -0,0 +1,120 @@
+package formats
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll returns the values of each field in every record, with multiple
+// values joined by "|".
+func readAll(t *testing.T, r Reader) []map[string]string {
+	t.Helper()
+	var recs []map[string]string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, f := range rec.Fields() {
+			m[f] = strings.Join(rec.Values(f), "|")
+		}
+		recs = append(recs, m)
+	}
+}
+
+func TestJSONReader(t *testing.T) {
+	r := openString(t, ".json", `[
+	{"id": "ENSG01", "score": 1.50, "ok": true, "xref": {"hgnc": "HGNC:1", "uniprot": ["P1", "P2"]}},
+	{"id": "ENSG02", "score": null, "xref": [{"hgnc": "HGNC:2"}, {"hgnc": "HGNC:3"}]}
+]`)
+	want := []map[string]string{
+		{"id": "ENSG01", "score": "1.50", "ok": "true", "xref.hgnc": "HGNC:1", "xref.uniprot": "P1|P2"},
+		{"id": "ENSG02", "score": "", "xref.hgnc": "HGNC:2|HGNC:3"},
+	}
+	if got := readAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
+
+func TestJSONSingleObject(t *testing.T) {
+	r := openString(t, ".json", `{"id": "ENSG01", "name": "A"}`)
+	want := []map[string]string{{"id": "ENSG01", "name": "A"}}
+	if got := readAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	r := openString(t, ".ndjson", "{\"id\": \"ENSG01\", \"n\": 1}\n\n  \n{\"id\": \"ENSG02\", \"tags\": [\"a\", \"b\"]}\n")
+	want := []map[string]string{
+		{"id": "ENSG01", "n": "1"},
+		{"id": "ENSG02", "tags": "a|b"},
+	}
+	if got := readAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	r = openString(t, ".ndjson", "{\"id\": \"ENSG01\"}\n[1, 2]\n")
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != errJSONNotObject {
+		t.Errorf("Next() on an array line = %v, want errJSONNotObject", err)
+	}
+	if err := r.Err(); err != errJSONNotObject {
+		t.Errorf("Err() = %v, want errJSONNotObject", err)
+	}
+}
+
+func TestJSONWriteKeepsStructure(t *testing.T) {
+	r := openString(t, ".json", `[{"id": "ENSG01", "xref": [{"hgnc": "HGNC:1"}, {"hgnc": "HGNC:2"}], "n": 2}]`)
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Set("xref.hgnc", []string{"A1", "A2"})
+	rec.Set("id", []string{"ENSG09"})
+
+	var buf bytes.Buffer
+	w := NewNDJSONWriter(&buf)
+	if err = w.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"ENSG09","xref":[{"hgnc":"A1"},{"hgnc":"A2"}],"n":2}` + "\n"
+	if buf.String() != want {
+		t.Errorf("wrote %s, want %s", buf.String(), want)
+	}
+}
+
+func TestDetectJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     string
+		json, nd bool
+	}{
+		{"array", `[{"a": 1}, {"a": 2}]`, true, false},
+		{"object", `{"a": 1}`, true, false},
+		{"scalars", `[1, 2, 3]`, false, false},
+		{"lines", "{\"a\": 1}\n{\"a\": 2}\n", false, true},
+		{"csv", "a,b\n1,2\n", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, _ := detectJSON([]byte(tc.data), false); got != tc.json {
+				t.Errorf("detectJSON = %v, want %v", got, tc.json)
+			}
+			if got, _ := detectNDJSON([]byte(tc.data), false); got != tc.nd {
+				t.Errorf("detectNDJSON = %v, want %v", got, tc.nd)
+			}
+		})
+	}
+}
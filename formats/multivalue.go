@@ -0,0 +1,186 @@
+package formats
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// number of records used to infer multi-value delimiters
+	multiValueSampleRows = 1000
+
+	// longest value that looks like an identifier in a list
+	multiValueMaxLen = 64
+)
+
+var (
+	// candidate multi-value delimiters, in order of preference
+	multiValueDelimiters = []string{"///", ";", "|", ","}
+
+	decimalComma = regexp.MustCompile(`^-?[0-9]+,[0-9]+$`)
+)
+
+// MultiValueReader is a Reader for documents which store multiple values
+// within a single field, separated by a delimiter inferred for each field.
+type MultiValueReader interface {
+	Reader
+
+	// Delimiters returns the delimiter used to split each field with multiple
+	// values. Fields that are not listed are single-valued.
+	Delimiters() map[string]string
+
+	// SetDelimiter overrides the delimiter used to split the named field.
+	// An empty delimiter indicates that the field is single-valued.
+	SetDelimiter(field, delim string)
+}
+
+// multiSplitter infers and applies per-field multi-value delimiters, and
+// infers the schema of the split values.
+type multiSplitter struct {
+	inferred  map[string]string
+	overrides map[string]string
+
+	// sample used for inference, and the schema inferred from it
+	head   []string
+	sample [][]string
+	schema Schema
+}
+
+// Delimiters returns the delimiter used to split each multi-valued field.
+// (Implements the formats.MultiValueReader interface)
+func (m *multiSplitter) Delimiters() map[string]string {
+	res := make(map[string]string)
+	for f, d := range m.inferred {
+		res[f] = d
+	}
+	for f, d := range m.overrides {
+		if d == "" {
+			delete(res, f)
+		} else {
+			res[f] = d
+		}
+	}
+	return res
+}
+
+// SetDelimiter overrides the delimiter used to split the named field.
+// (Implements the formats.MultiValueReader interface)
+func (m *multiSplitter) SetDelimiter(field, delim string) {
+	if m.overrides == nil {
+		m.overrides = make(map[string]string)
+	}
+	m.overrides[field] = delim
+	m.schema = nil
+}
+
+// Schema returns the schema inferred from the sample of records, using the
+// current multi-value delimiters.
+// (Implements the formats.SchemaReader interface)
+func (m *multiSplitter) Schema() Schema {
+	if m.schema == nil {
+		recs := make([]Record, len(m.sample))
+		for i, cols := range m.sample {
+			recs[i] = &simpleRec{fields: m.head, values: m.splitRow(m.head, cols)}
+		}
+		m.schema = InferSchema(recs)
+		for _, field := range m.head {
+			if m.schema.Field(field) == nil {
+				m.schema = append(m.schema, &Field{Name: field, Type: TypeString})
+			}
+		}
+	}
+	return m.schema
+}
+
+// inferDelimiters determines the multi-value delimiter for each column of
+// the sample rows. The exclude string is the field delimiter of the document.
+func (m *multiSplitter) inferDelimiters(head []string, rows [][]string, exclude string) {
+	m.inferred = make(map[string]string)
+	m.head, m.sample, m.schema = head, rows, nil
+	for i, field := range head {
+		var sample []string
+		for _, row := range rows {
+			if i < len(row) && strings.TrimSpace(row[i]) != "" {
+				sample = append(sample, strings.TrimSpace(row[i]))
+			}
+		}
+		if d := inferMultiValueDelimiter(sample, exclude); d != "" {
+			m.inferred[field] = d
+		}
+	}
+}
+
+// splitRow splits each column of a row into values.
+func (m *multiSplitter) splitRow(head, cols []string) [][]string {
+	vals := make([][]string, len(cols))
+	for i, c := range cols {
+		d := ""
+		if i < len(head) {
+			var ok bool
+			if d, ok = m.overrides[head[i]]; !ok {
+				d = m.inferred[head[i]]
+			}
+		}
+		if d == "" {
+			vals[i] = []string{c}
+			continue
+		}
+		vals[i] = strings.Split(c, d)
+		for j, v := range vals[i] {
+			vals[i][j] = strings.TrimSpace(v)
+		}
+	}
+	return vals
+}
+
+// inferMultiValueDelimiter chooses the candidate delimiter found in the most
+// values, provided that the column looks like a list of identifiers, i.e.
+// most values split into short tokens without whitespace.
+func inferMultiValueDelimiter(sample []string, exclude string) string {
+	if len(sample) == 0 {
+		return ""
+	}
+
+	best, bestCount := "", 0
+	for _, d := range multiValueDelimiters {
+		if d == exclude {
+			continue
+		}
+		count, decimals, tokens := 0, 0, 0
+		for _, s := range sample {
+			if strings.Contains(s, d) {
+				count++
+				if d == "," && decimalComma.MatchString(s) {
+					decimals++
+				}
+			}
+			if isTokenList(s, d) {
+				tokens++
+			}
+		}
+		if count == 0 || decimals == count {
+			continue
+		}
+		if tokens*10 < len(sample)*9 {
+			// free text
+			continue
+		}
+		if count > bestCount {
+			best, bestCount = d, count
+		}
+	}
+	return best
+}
+
+// isTokenList is true if every part of s split by d is a short token
+// without whitespace.
+func isTokenList(s, d string) bool {
+	for _, part := range strings.Split(s, d) {
+		part = strings.TrimSpace(part)
+		if part == "" || len(part) > multiValueMaxLen || strings.IndexFunc(part, unicode.IsSpace) != -1 {
+			return false
+		}
+	}
+	return true
+}
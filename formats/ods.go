@@ -0,0 +1,311 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+	odsTableNS  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	odsOfficeNS = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	odsTextNS   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+
+	// longest run of compressed whitespace that is expanded
+	odsMaxSpaces = 1024
+)
+
+var (
+	_ = Register(&Format{
+		Name:        "OpenDocument ODS",
+		Description: "OpenDocument Spreadsheet",
+		Extensions:  []string{".ods"},
+		MediaTypes:  []string{odsMimeType},
+		Detect:      detectODS,
+		NewReader: func(r io.Reader) (Reader, error) {
+			// TODO: this'll panic if necessary, but we could do it cleaner later
+			return OpenODS(r.(io.ReadSeeker))
+		},
+		NewWriter: writerNotSupported,
+	})
+)
+
+// isODS checks for the uncompressed "mimetype" file that ODF documents
+// store as the first entry of the zip container.
+func isODS(data []byte) bool {
+	const nameOffset = 30 // size of the zip local file header
+	if len(data) < nameOffset+len("mimetype")+len(odsMimeType) {
+		return false
+	}
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return false
+	}
+	data = data[nameOffset:]
+	if !bytes.HasPrefix(data, []byte("mimetype")) {
+		return false
+	}
+	return bytes.HasPrefix(data[len("mimetype"):], []byte(odsMimeType))
+}
+
+func detectODS(data []byte, incomplete bool) (supported, more bool) {
+	if len(data) < 128 && incomplete {
+		return false, true
+	}
+	return isODS(data), false
+}
+
+// ODS supports reading tabular records from an OpenDocument spreadsheet.
+type ODS struct {
+	sheetGrid
+
+	content *zip.File
+}
+
+// OpenODS opens an OpenDocument spreadsheet and returns a formats.Reader.
+func OpenODS(in io.ReadSeeker) (*ODS, error) {
+	ra, size, err := readerAt(in)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x := &ODS{}
+	x.load = x.loadSheet
+	for _, zf := range zr.File {
+		if zf.Name == "content.xml" {
+			x.content = zf
+		}
+	}
+	if x.content == nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x.sheets, err = x.sheetNames()
+	if err != nil {
+		return nil, err
+	}
+	if len(x.sheets) == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x.selectSheet(0)
+	return x, x.stickyErr
+}
+
+// sheetNames scans the document for the table names.
+func (x *ODS) sheetNames() ([]string, error) {
+	rc, err := x.content.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var names []string
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Space != odsTableNS || se.Name.Local != "table" {
+			continue
+		}
+		names = append(names, odsAttr(se, odsTableNS, "name"))
+		if err = dec.Skip(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (x *ODS) loadSheet(sheet int) ([][]string, error) {
+	rc, err := x.content.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(rc)
+	n := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Space != odsTableNS || se.Name.Local != "table" {
+			continue
+		}
+		if n == sheet {
+			return odsReadTable(dec)
+		}
+		n++
+		if err = dec.Skip(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// odsReadTable reads the rows of the current table element. Repeated rows
+// and cells are expanded up to the sheet size limits, except for trailing
+// blanks which are commonly repeated to fill the entire sheet.
+func odsReadTable(dec *xml.Decoder) ([][]string, error) {
+	var rows [][]string
+	blankRows := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if t.Name.Space == odsTableNS && t.Name.Local == "table" {
+				return rows, nil
+			}
+
+		case xml.StartElement:
+			if t.Name.Space != odsTableNS || t.Name.Local != "table-row" {
+				// table-row elements may be within header-rows or row-group
+				continue
+			}
+			row, err := odsReadRow(dec)
+			if err != nil {
+				return nil, err
+			}
+			repeat := odsRepeat(t, "number-rows-repeated", sheetMaxRows)
+			if len(row) == 0 {
+				blankRows += repeat
+				continue
+			}
+			for ; blankRows > 0 && len(rows) < sheetMaxRows; blankRows-- {
+				rows = append(rows, nil)
+			}
+			blankRows = 0
+			for i := 0; i < repeat && len(rows) < sheetMaxRows; i++ {
+				rows = append(rows, row)
+			}
+		}
+	}
+}
+
+func odsReadRow(dec *xml.Decoder) ([]string, error) {
+	var cols []string
+	blankCols := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if t.Name.Space == odsTableNS && t.Name.Local == "table-row" {
+				return cols, nil
+			}
+
+		case xml.StartElement:
+			if t.Name.Space != odsTableNS ||
+				(t.Name.Local != "table-cell" && t.Name.Local != "covered-table-cell") {
+				continue
+			}
+			val, err := odsReadCell(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			repeat := odsRepeat(t, "number-columns-repeated", sheetMaxCols)
+			if val == "" {
+				blankCols += repeat
+				continue
+			}
+			for ; blankCols > 0 && len(cols) < sheetMaxCols; blankCols-- {
+				cols = append(cols, "")
+			}
+			blankCols = 0
+			for i := 0; i < repeat && len(cols) < sheetMaxCols; i++ {
+				cols = append(cols, val)
+			}
+		}
+	}
+}
+
+func odsReadCell(dec *xml.Decoder, se xml.StartElement) (string, error) {
+	var paras []string
+	var text strings.Builder
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Space == odsTextNS && t.Name.Local == "s" {
+				// compressed whitespace
+				n, err := strconv.Atoi(odsAttr(t, odsTextNS, "c"))
+				if err != nil || n < 1 {
+					n = 1
+				}
+				if n > odsMaxSpaces {
+					n = odsMaxSpaces
+				}
+				text.WriteString(strings.Repeat(" ", n))
+			}
+		case xml.CharData:
+			if depth > 0 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				// typed values are stored unformatted in attributes
+				switch odsAttr(se, odsOfficeNS, "value-type") {
+				case "float", "percentage", "currency":
+					return odsAttr(se, odsOfficeNS, "value"), nil
+				case "date":
+					return odsAttr(se, odsOfficeNS, "date-value"), nil
+				case "time":
+					return odsAttr(se, odsOfficeNS, "time-value"), nil
+				case "boolean":
+					return odsAttr(se, odsOfficeNS, "boolean-value"), nil
+				}
+				return strings.Join(paras, "\n"), nil
+			}
+			depth--
+			if depth == 0 && t.Name.Space == odsTextNS && t.Name.Local == "p" {
+				paras = append(paras, text.String())
+				text.Reset()
+			}
+		}
+	}
+}
+
+func odsAttr(se xml.StartElement, space, local string) string {
+	for _, a := range se.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// odsRepeat returns the repeat count of a row or cell, at most limit.
+func odsRepeat(se xml.StartElement, attr string, limit int) int {
+	n, err := strconv.Atoi(odsAttr(se, odsTableNS, attr))
+	if err != nil || n < 1 {
+		return 1
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+package formats
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readODSTable reads the rows of the first table in an ODS content body.
+func readODSTable(t *testing.T, body string) [][]string {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(`<office:body
+		xmlns:office="` + odsOfficeNS + `" xmlns:table="` + odsTableNS + `" xmlns:text="` + odsTextNS + `">
+		<table:table table:name="Genes">` + body + `</table:table></office:body>`))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "table" {
+			rows, err := odsReadTable(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return rows
+		}
+	}
+}
+
+func TestODSRepeats(t *testing.T) {
+	rows := readODSTable(t, `
+<table:table-row><table:table-cell><text:p>id</text:p></table:table-cell><table:table-cell table:number-columns-repeated="2"/><table:table-cell table:number-columns-repeated="2"><text:p>x</text:p></table:table-cell></table:table-row>
+<table:table-row table:number-rows-repeated="2"/>
+<table:table-row table:number-rows-repeated="2"><table:table-cell><text:p>a<text:s text:c="3"/>b</text:p></table:table-cell></table:table-row>
+<table:table-row table:number-rows-repeated="1048000"><table:table-cell/></table:table-row>`)
+	want := [][]string{
+		{"id", "", "", "x", "x"},
+		nil,
+		nil,
+		{"a   b"},
+		{"a   b"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestODSRepeatLimits(t *testing.T) {
+	rows := readODSTable(t, `
+<table:table-row table:number-rows-repeated="2000000000"><table:table-cell table:number-columns-repeated="1000000000"><text:p>x<text:s text:c="1000000000"/></text:p></table:table-cell></table:table-row>
+<table:table-row><table:table-cell><text:p>past the end</text:p></table:table-cell></table:table-row>`)
+	if len(rows) != sheetMaxRows {
+		t.Fatalf("got %d rows, want %d", len(rows), sheetMaxRows)
+	}
+	if len(rows[0]) != sheetMaxCols {
+		t.Errorf("got %d columns, want %d", len(rows[0]), sheetMaxCols)
+	}
+	if want := "x" + strings.Repeat(" ", odsMaxSpaces); rows[0][0] != want {
+		t.Errorf("got a cell of %d characters, want %d", len(rows[0][0]), len(want))
+	}
+}
@@ -0,0 +1,546 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	// number of rows to read from each column at a time
+	parquetBatchRows = 4096
+
+	// number of goroutines used by the parquet library
+	parquetParallel = 4
+)
+
+var (
+	_ = Register(&Format{
+		Name:        "Parquet",
+		Description: "Apache Parquet",
+		Extensions:  []string{".parquet"},
+		MediaTypes:  []string{"application/vnd.apache.parquet"},
+		Detect:      detectParquet,
+		NewReader: func(r io.Reader) (Reader, error) {
+			ra, ok := r.(parquetInput)
+			if !ok {
+				return nil, ErrUnsupportedFormat
+			}
+			return OpenParquet(ra)
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return NewParquetWriter(w), nil
+		},
+	})
+
+	parquetMagic = []byte("PAR1")
+)
+
+func detectParquet(data []byte, incomplete bool) (supported, more bool) {
+	if len(data) < len(parquetMagic) {
+		return false, incomplete
+	}
+	if !bytes.HasPrefix(data, parquetMagic) {
+		return false, false
+	}
+	if incomplete {
+		// the footer is at the end of the file
+		return true, false
+	}
+	return bytes.HasSuffix(data, parquetMagic), false
+}
+
+// parquetInput is required because parquet metadata is stored in a footer,
+// and each column is read independently.
+type parquetInput interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// Parquet supports reading tabular records from an Apache Parquet file.
+//
+// Columns are read in batches of rows, so only a small portion of the file
+// is held in memory at a time. List columns become multi-valued fields,
+// and nested groups are flattened into dotted field names.
+type Parquet struct {
+	pr *reader.ParquetReader
+
+	head  []string
+	cols  []*parquetColumn
+	nrows int64
+	nread int64
+
+	batch [][][]string
+	pos   int
+
+	stickyErr error
+}
+
+type parquetColumn struct {
+	index int
+	field int
+	elem  *parquet.SchemaElement
+}
+
+// OpenParquet opens a parquet document and returns a formats.Reader.
+func OpenParquet(in parquetInput) (*Parquet, error) {
+	size, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := reader.NewParquetColumnReader(newParquetFile(in, size), parquetParallel)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x := &Parquet{
+		pr:    pr,
+		nrows: pr.GetNumRows(),
+	}
+
+	sh := pr.SchemaHandler
+	fieldIndex := make(map[string]int)
+	for i, inPath := range sh.ValueColumns {
+		name := x.fieldName(sh.InPathToExPath[inPath])
+		fi, ok := fieldIndex[name]
+		if !ok {
+			fi = len(x.head)
+			fieldIndex[name] = fi
+			x.head = append(x.head, name)
+		}
+		x.cols = append(x.cols, &parquetColumn{
+			index: i,
+			field: fi,
+			elem:  sh.SchemaElements[sh.MapIndex[inPath]],
+		})
+	}
+	return x, nil
+}
+
+// fieldName determines the field name for a leaf column, skipping the
+// root element and the intermediate groups used to encode lists and maps.
+func (x *Parquet) fieldName(exPath string) string {
+	sh := x.pr.SchemaHandler
+	path := common.StrToPath(exPath)
+	var parts []string
+	for i := 1; i < len(path); i++ {
+		parts = append(parts, path[i])
+		elem := sh.SchemaElements[sh.MapIndex[sh.ExPathToInPath[common.PathToStr(path[:i+1])]]]
+		switch elem.GetConvertedType() {
+		case parquet.ConvertedType_LIST:
+			// skip the repeated group and the element
+			i += 2
+		case parquet.ConvertedType_MAP, parquet.ConvertedType_MAP_KEY_VALUE:
+			// skip the repeated key_value group
+			i++
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Next returns the next Record in the document.
+// (Implements the formats.Reader interface)
+func (x *Parquet) Next() (Record, error) {
+	if x.stickyErr != nil {
+		return nil, x.stickyErr
+	}
+	if x.pos >= len(x.batch) {
+		x.readBatch()
+		if x.stickyErr != nil {
+			return nil, x.stickyErr
+		}
+	}
+
+	vals := x.batch[x.pos]
+	x.batch[x.pos] = nil
+	x.pos++
+	return &simpleRec{
+		fields: x.head,
+		values: vals,
+	}, nil
+}
+
+func (x *Parquet) readBatch() {
+	if x.nread >= x.nrows {
+		x.stickyErr = io.EOF
+		return
+	}
+	n := x.nrows - x.nread
+	if n > parquetBatchRows {
+		n = parquetBatchRows
+	}
+
+	batch := make([][][]string, n)
+	for i := range batch {
+		batch[i] = make([][]string, len(x.head))
+	}
+	for _, col := range x.cols {
+		values, rls, _, err := x.pr.ReadColumnByIndex(int64(col.index), n)
+		if err != nil {
+			x.stickyErr = err
+			return
+		}
+		row := -1
+		for i, v := range values {
+			if rls[i] == 0 {
+				row++
+			}
+			if v == nil || row < 0 || row >= len(batch) {
+				continue
+			}
+			batch[row][col.field] = append(batch[row][col.field], parquetString(col.elem, v))
+		}
+	}
+	x.nread += n
+	x.batch = batch
+	x.pos = 0
+}
+
+// Err returns the last error that occured.
+func (x *Parquet) Err() error {
+	return x.stickyErr
+}
+
+// parquetString formats a parquet value according to its schema element.
+func parquetString(elem *parquet.SchemaElement, v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case int32:
+		if elem.GetConvertedType() == parquet.ConvertedType_DATE {
+			return time.Unix(int64(t)*86400, 0).UTC().Format("2006-01-02")
+		}
+		return strconv.FormatInt(int64(t), 10)
+	case int64:
+		switch elem.GetConvertedType() {
+		case parquet.ConvertedType_TIMESTAMP_MILLIS:
+			return time.Unix(0, t*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+		case parquet.ConvertedType_TIMESTAMP_MICROS:
+			return time.Unix(0, t*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+		}
+		return strconv.FormatInt(t, 10)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// parquetFile adapts an io.ReaderAt to the source.ParquetFile interface.
+// The parquet library opens an independent handle for each column.
+type parquetFile struct {
+	*io.SectionReader
+
+	r    io.ReaderAt
+	size int64
+}
+
+func newParquetFile(r io.ReaderAt, size int64) *parquetFile {
+	return &parquetFile{
+		SectionReader: io.NewSectionReader(r, 0, size),
+		r:             r,
+		size:          size,
+	}
+}
+
+func (f *parquetFile) Open(name string) (source.ParquetFile, error) {
+	return newParquetFile(f.r, f.size), nil
+}
+
+func (f *parquetFile) Create(name string) (source.ParquetFile, error) {
+	return nil, ErrWriterNotSupported
+}
+
+func (f *parquetFile) Write(p []byte) (int, error) {
+	return 0, ErrWriterNotSupported
+}
+
+func (f *parquetFile) Close() error {
+	return nil
+}
+
+///////////
+
+// ParquetWriter serializes records to an Apache Parquet file.
+//
+// Parquet columns have a fixed type, so the records are spooled to a
+// temporary file and the schema is determined from all of them when the
+// writer is closed. Integer, float and boolean columns are written as
+// numbers and booleans, and other columns as strings, which includes any
+// column with a value that doesn't parse as the type of the others.
+// Columns that contain multiple values in any record are written as lists.
+type ParquetWriter struct {
+	w  io.Writer
+	pw *writer.JSONWriter
+
+	// given is the schema set by SetSchema
+	given  Schema
+	schema Schema
+
+	// the value type of each field, and whether it is a list, in the
+	// order the fields were first written
+	fields []string
+	types  map[string]FieldType
+	lists  map[string]bool
+
+	spool *os.File
+	buf   *bufio.Writer
+	enc   *gob.Encoder
+
+	stickyErr error
+}
+
+// parquetSpooled is a Record in the spool file.
+type parquetSpooled struct {
+	Fields []string
+	Values [][]string
+}
+
+// NewParquetWriter returns a formats.Writer that emits a parquet file.
+func NewParquetWriter(w io.Writer) *ParquetWriter {
+	return &ParquetWriter{
+		w:     w,
+		types: make(map[string]FieldType),
+		lists: make(map[string]bool),
+	}
+}
+
+// SetSchema sets the types of the fields. A field keeps its type unless
+// one of its values doesn't parse as that type, and fields which aren't in
+// the schema are inferred from their values.
+// (Implements the formats.SchemaWriter interface)
+func (x *ParquetWriter) SetSchema(s Schema) {
+	x.given = s
+}
+
+// Write serializes the Record.
+// (Implements the formats.Writer interface)
+func (x *ParquetWriter) Write(rec Record) error {
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	if x.spool == nil {
+		x.spool, x.stickyErr = ioutil.TempFile("", "databio-*.parquet-rows")
+		if x.stickyErr != nil {
+			return x.stickyErr
+		}
+		x.buf = bufio.NewWriter(x.spool)
+		x.enc = gob.NewEncoder(x.buf)
+	}
+
+	sp := parquetSpooled{Fields: rec.Fields()}
+	for _, field := range sp.Fields {
+		if _, ok := x.types[field]; !ok {
+			x.fields = append(x.fields, field)
+			x.types[field] = ""
+			if f := x.given.Field(field); f != nil {
+				x.types[field] = x.given.ValueType(field)
+				x.lists[field] = f.Type == TypeList
+			}
+		}
+		vals := rec.Values(field)
+		if len(vals) > 1 {
+			x.lists[field] = true
+		}
+		for _, v := range vals {
+			x.types[field] = mergeValueType(x.types[field], v)
+		}
+		sp.Values = append(sp.Values, vals)
+	}
+	x.stickyErr = x.enc.Encode(&sp)
+	return x.stickyErr
+}
+
+// start determines the schema of the spooled records and writes them.
+func (x *ParquetWriter) start() {
+	for _, field := range x.fields {
+		f := &Field{Name: field, Type: x.types[field]}
+		if f.Type == "" {
+			f.Type = TypeString
+		}
+		if x.lists[field] {
+			f.Type, f.Elem = TypeList, f.Type
+		}
+		x.schema = append(x.schema, f)
+	}
+	x.pw, x.stickyErr = writer.NewJSONWriterFromWriter(x.parquetSchema(), x.w, parquetParallel)
+	if x.stickyErr != nil {
+		return
+	}
+	x.pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	if x.spool == nil {
+		return
+	}
+
+	if x.stickyErr = x.buf.Flush(); x.stickyErr != nil {
+		return
+	}
+	if _, x.stickyErr = x.spool.Seek(0, io.SeekStart); x.stickyErr != nil {
+		return
+	}
+	dec := gob.NewDecoder(bufio.NewReader(x.spool))
+	for {
+		var sp parquetSpooled
+		if err := dec.Decode(&sp); err != nil {
+			if err != io.EOF {
+				x.stickyErr = err
+			}
+			return
+		}
+		x.write(&simpleRec{fields: sp.Fields, values: sp.Values})
+		if x.stickyErr != nil {
+			return
+		}
+	}
+}
+
+func (x *ParquetWriter) write(rec Record) {
+	var row string
+	row, x.stickyErr = x.row(rec)
+	if x.stickyErr != nil {
+		return
+	}
+	x.stickyErr = x.pw.Write(row)
+}
+
+type parquetSchema struct {
+	Tag    string
+	Fields []*parquetSchema `json:",omitempty"`
+}
+
+func (x *ParquetWriter) parquetSchema() string {
+	root := &parquetSchema{Tag: "name=databio, repetitiontype=REQUIRED"}
+	for _, field := range x.schema {
+		tag := "name=" + parquetColumnName(field.Name)
+		if field.Type != TypeList {
+			root.Fields = append(root.Fields, &parquetSchema{
+				Tag: tag + ", " + parquetType(field.Type) + ", repetitiontype=OPTIONAL",
+			})
+			continue
+		}
+		root.Fields = append(root.Fields, &parquetSchema{
+			Tag: tag + ", type=LIST, repetitiontype=OPTIONAL",
+			Fields: []*parquetSchema{{
+				Tag: "name=element, " + parquetType(field.Elem) + ", repetitiontype=REQUIRED",
+			}},
+		})
+	}
+	b, _ := json.Marshal(root)
+	return string(b)
+}
+
+// parquetType returns the schema tag for the values of a field type.
+func parquetType(t FieldType) string {
+	switch t {
+	case TypeInt:
+		return "type=INT64"
+	case TypeFloat:
+		return "type=DOUBLE"
+	case TypeBool:
+		return "type=BOOLEAN"
+	}
+	return "type=BYTE_ARRAY, convertedtype=UTF8"
+}
+
+// row encodes a Record into the JSON form expected by the parquet library.
+func (x *ParquetWriter) row(rec Record) (string, error) {
+	obj := make(map[string]interface{}, len(x.schema))
+	for _, field := range x.schema {
+		vals := rec.Values(field.Name)
+		var err error
+		switch field.Type {
+		case TypeList:
+			var elems []interface{}
+			for _, v := range vals {
+				if strings.TrimSpace(v) == "" && field.Elem != TypeString {
+					continue
+				}
+				e, err := parquetValue(field.Elem, v)
+				if err != nil {
+					return "", err
+				}
+				elems = append(elems, e)
+			}
+			obj[parquetColumnName(field.Name)] = elems
+		case TypeString, TypeDate:
+			if len(vals) > 0 {
+				obj[parquetColumnName(field.Name)] = vals[0]
+			}
+		default:
+			if len(vals) > 0 && strings.TrimSpace(vals[0]) != "" {
+				obj[parquetColumnName(field.Name)], err = parquetValue(field.Type, vals[0])
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	b, err := json.Marshal(obj)
+	return string(b), err
+}
+
+// parquetValue converts a value to the JSON type expected for the field type.
+func parquetValue(t FieldType, v string) (interface{}, error) {
+	var res interface{} = v
+	ok := true
+	switch t {
+	case TypeInt:
+		res, ok = parseInt(strings.TrimSpace(v))
+	case TypeFloat:
+		res, ok = parseFloat(strings.TrimSpace(v))
+	case TypeBool:
+		res, ok = parseBool(strings.TrimSpace(v))
+	}
+	if !ok {
+		return nil, ErrValueType
+	}
+	return res, nil
+}
+
+// Close finalizes the document and flushes any buffered data.
+// (Implements the formats.Writer interface)
+func (x *ParquetWriter) Close() error {
+	if x.spool != nil {
+		defer os.Remove(x.spool.Name())
+		defer x.spool.Close()
+	}
+	if x.stickyErr != nil {
+		return x.stickyErr
+	}
+	if x.pw == nil {
+		x.start()
+		if x.stickyErr != nil {
+			return x.stickyErr
+		}
+	}
+	x.stickyErr = x.pw.WriteStop()
+	return x.stickyErr
+}
+
+// Err returns the last error that occured.
+func (x *ParquetWriter) Err() error {
+	return x.stickyErr
+}
+
+// parquetColumnName removes characters which can't be used in the parquet
+// library's schema tags.
+func parquetColumnName(field string) string {
+	return strings.NewReplacer(",", "_", "=", "_").Replace(field)
+}
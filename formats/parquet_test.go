@@ -0,0 +1,84 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+func TestParquetWriterTypes(t *testing.T) {
+	var recs []Record
+	for i := 0; i < 1500; i++ {
+		rec := &simpleRec{
+			fields: []string{"gene", "n", "m", "f"},
+			values: [][]string{{strconv.Itoa(1000 + i)}, {strconv.Itoa(i)}, {strconv.Itoa(i)}, {strconv.Itoa(i)}},
+		}
+		switch i {
+		case 7:
+			// translated identifiers which are numeric, with an ambiguous one
+			rec.values[0] = []string{"1", "2"}
+		case 1200:
+			rec.values[3] = []string{"1.5"}
+		case 1300:
+			rec.values[2] = []string{"1300", "1301"}
+		case 1400:
+			// past the rows a sample would have looked at
+			rec.values[1] = []string{"n/a"}
+		}
+		recs = append(recs, rec)
+	}
+
+	var buf bytes.Buffer
+	w := NewParquetWriter(&buf)
+	w.SetSchema(Schema{{Name: "gene", Type: TypeString}})
+	for _, rec := range recs {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenParquet(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]parquet.Type)
+	for _, col := range r.cols {
+		types[r.head[col.field]] = col.elem.GetType()
+	}
+	want := map[string]parquet.Type{
+		"gene": parquet.Type_BYTE_ARRAY,
+		"n":    parquet.Type_BYTE_ARRAY,
+		"m":    parquet.Type_INT64,
+		"f":    parquet.Type_DOUBLE,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("column types = %v, want %v", types, want)
+	}
+
+	for i := 0; ; i++ {
+		rec, err := r.Next()
+		if err == io.EOF {
+			if i != len(recs) {
+				t.Fatalf("read %d records, want %d", i, len(recs))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{"gene", "n", "m"} {
+			got, exp := rec.Values(field), recs[i].Values(field)
+			if fmt.Sprint(got) != fmt.Sprint(exp) {
+				t.Fatalf("record %d %s = %q, want %q", i, field, got, exp)
+			}
+		}
+	}
+}
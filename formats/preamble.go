@@ -0,0 +1,85 @@
+package formats
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maximum length of a line above the header row, such as a long VCF header
+const preambleMaxLineSize = 16 << 20
+
+// PreambleReader is a Reader for tabular documents which can contain
+// descriptive lines above the header row, such as provenance comments or
+// the metadata of a GEO series matrix.
+type PreambleReader interface {
+	Reader
+
+	// Preamble returns the lines above the header row of the table,
+	// including any comment lines, without line endings. The cells of
+	// spreadsheet rows are separated by tabs.
+	Preamble() []string
+}
+
+// PreambleWriter is a Writer which can emit descriptive lines above the
+// header row of each table.
+type PreambleWriter interface {
+	Writer
+
+	// SetPreamble sets the lines written above the header row of the
+	// current table. It must be called before the first Record of the
+	// table is written.
+	SetPreamble(lines []string)
+}
+
+// Preamble returns the lines above the header row of the table.
+// (Implements the formats.PreambleReader interface)
+func (h *headerInfo) Preamble() []string {
+	return h.preamble
+}
+
+// readPreamble returns the lines of a text document up to the record at
+// index row, counting records as lines which aren't blank or comments.
+func readPreamble(in io.Reader, row int, comment rune) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 64*1024), preambleMaxLineSize)
+	for s.Scan() {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		isRecord := strings.TrimSpace(line) != "" &&
+			(comment == 0 || !strings.HasPrefix(line, string(comment)))
+		if isRecord {
+			if row <= 0 {
+				break
+			}
+			row--
+		}
+		lines = append(lines, line)
+	}
+	return trimBlankLines(lines), s.Err()
+}
+
+// sheetPreamble returns the spreadsheet rows above the header row as
+// tab-separated lines.
+func sheetPreamble(rows [][]string) []string {
+	lines := make([]string, len(rows))
+	for i, cols := range rows {
+		n := len(cols)
+		for n > 0 && strings.TrimSpace(cols[n-1]) == "" {
+			n--
+		}
+		lines[i] = strings.Join(cols[:n], "\t")
+	}
+	return trimBlankLines(lines)
+}
+
+// trimBlankLines removes blank lines from the end of the preamble.
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines
+}
@@ -0,0 +1,26 @@
+package formats
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreambleLongLines(t *testing.T) {
+	long := "##INFO=<Description=\"" + strings.Repeat("x", 200<<10) + "\">"
+	r := openString(t, ".csv", long+"\n#comment\nname,id\nA,1\nB,2\nC,3\n")
+	hr := r.(HeaderReader)
+	if err := hr.SetHeaderRow(0); err != nil {
+		t.Fatal(err)
+	}
+	got := r.(PreambleReader).Preamble()
+	if len(got) != 2 || got[0] != long || got[1] != "#comment" {
+		t.Errorf("preamble has %d lines, want the long line and a comment", len(got))
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := rec.Values("id"); len(v) != 1 || v[0] != "1" {
+		t.Errorf("id = %q, want 1", v)
+	}
+}
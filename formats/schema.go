@@ -0,0 +1,213 @@
+package formats
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldType is the type of the values in a field.
+type FieldType string
+
+// Field types inferred from the values of a field. The types are listed
+// from the most to the least specific, except that TypeList fields contain
+// multiple values of another type.
+const (
+	TypeInt    FieldType = "int"
+	TypeFloat  FieldType = "float"
+	TypeBool   FieldType = "bool"
+	TypeDate   FieldType = "date"
+	TypeString FieldType = "string"
+	TypeList   FieldType = "list"
+)
+
+var (
+	// ErrValueType is returned when a value can't be parsed as the
+	// requested type.
+	ErrValueType = errors.New("databio/formats: value does not match the field type")
+
+	// layouts of the values parsed as TypeDate
+	dateLayouts = []string{
+		"2006-01-02",
+		"2006-01-02 15:04:05",
+		time.RFC3339Nano,
+	}
+)
+
+// Field describes a single field of a Schema.
+type Field struct {
+	// Name of the field.
+	Name string `json:"name"`
+
+	// Type of the values in the field.
+	Type FieldType `json:"type"`
+
+	// Elem is the type of each value in a TypeList field.
+	Elem FieldType `json:"elem,omitempty"`
+}
+
+// Schema describes the fields of the records in a table.
+type Schema []*Field
+
+// Field returns the named field in the schema, or nil if not found.
+func (s Schema) Field(name string) *Field {
+	for _, f := range s {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// ValueType returns the type of the values in the named field, which is
+// the Elem type for lists. Unknown fields are TypeString.
+func (s Schema) ValueType(name string) FieldType {
+	f := s.Field(name)
+	switch {
+	case f == nil:
+		return TypeString
+	case f.Type == TypeList:
+		return f.Elem
+	}
+	return f.Type
+}
+
+// SchemaReader is a Reader which infers the Schema of the records in each
+// table from a sample of the records.
+type SchemaReader interface {
+	Reader
+
+	// Schema returns the inferred schema of the current table.
+	Schema() Schema
+}
+
+// SchemaWriter is a Writer which can use a Schema to encode typed values,
+// such as numeric spreadsheet cells.
+type SchemaWriter interface {
+	Writer
+
+	// SetSchema sets the schema of the current table. It must be called
+	// before the first Record of the table is written.
+	SetSchema(s Schema)
+}
+
+// InferSchema determines the schema of a sample of records. Fields with
+// multiple values in any record are lists.
+func InferSchema(recs []Record) Schema {
+	var s Schema
+	values := make(map[string][]string)
+	lists := make(map[string]bool)
+	for _, rec := range recs {
+		for _, field := range rec.Fields() {
+			if _, ok := values[field]; !ok {
+				values[field] = nil
+				s = append(s, &Field{Name: field})
+			}
+			vals := rec.Values(field)
+			if len(vals) > 1 {
+				lists[field] = true
+			}
+			values[field] = append(values[field], vals...)
+		}
+	}
+	for _, f := range s {
+		f.Type = InferType(values[f.Name])
+		if lists[f.Name] {
+			f.Type, f.Elem = TypeList, f.Type
+		}
+	}
+	return s
+}
+
+// InferType returns the most specific type of all of the values. Blank
+// values are ignored, and fields without any values are TypeString.
+func InferType(values []string) FieldType {
+	var t FieldType
+	for _, v := range values {
+		if t = mergeValueType(t, v); t == TypeString {
+			return TypeString
+		}
+	}
+	if t == "" {
+		return TypeString
+	}
+	return t
+}
+
+// mergeValueType returns the most specific type of both the values of type
+// t and the value v. Blank values are ignored, and a blank t has no values.
+func mergeValueType(t FieldType, v string) FieldType {
+	if strings.TrimSpace(v) == "" {
+		return t
+	}
+	vt := ValueType(v)
+	switch {
+	case t == "" || t == vt:
+		return vt
+	case (t == TypeInt && vt == TypeFloat) || (t == TypeFloat && vt == TypeInt):
+		return TypeFloat
+	}
+	return TypeString
+}
+
+// ValueType returns the most specific type of a single value.
+func ValueType(v string) FieldType {
+	v = strings.TrimSpace(v)
+	if _, ok := parseInt(v); ok {
+		return TypeInt
+	}
+	if _, ok := parseFloat(v); ok {
+		return TypeFloat
+	}
+	if _, ok := parseBool(v); ok {
+		return TypeBool
+	}
+	if _, ok := parseDate(v); ok {
+		return TypeDate
+	}
+	return TypeString
+}
+
+// hasLeadingZero is true for numbers like "007" which are usually codes
+// that would be changed by converting to a number.
+func hasLeadingZero(v string) bool {
+	v = strings.TrimLeft(v, "+-")
+	return len(v) > 1 && v[0] == '0' && v[1] >= '0' && v[1] <= '9'
+}
+
+func parseInt(v string) (int64, bool) {
+	if hasLeadingZero(v) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
+
+func parseFloat(v string) (float64, bool) {
+	if hasLeadingZero(v) || strings.IndexAny(v, "0123456789") == -1 {
+		// also excludes NaN and Inf, which are more likely to be text
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil
+}
+
+func parseBool(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "true", "yes":
+		return true, true
+	case "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+func parseDate(v string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,178 @@
+package formats
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	// check at most 5000 rows for header content
+	sheetHeaderCheckMaxRows = 5000
+
+	// size limits of a worksheet in Excel and LibreOffice
+	sheetMaxRows = 1048576
+	sheetMaxCols = 16384
+)
+
+var errSheetLimit = errors.New("databio/formats: cell outside of the sheet size limits")
+
+// trimSheetRow trims the cells of a spreadsheet row, dropping blank cells
+// and naming any blank cells that appear before a non-blank one.
+func trimSheetRow(cols []string) []string {
+	truecols := make([]string, 0, len(cols))
+	for i, c := range cols {
+		if strings.TrimSpace(c) == "" {
+			continue
+		}
+		for i > len(truecols) {
+			truecols = append(truecols, columnName(len(truecols)))
+		}
+		truecols = append(truecols, strings.TrimSpace(c))
+	}
+	return truecols
+}
+
+// sheetGrid supports reading tabular records from spreadsheet documents
+// which are loaded into memory one sheet at a time.
+type sheetGrid struct {
+	sheets       []string
+	currentSheet int
+
+	// load returns the rows of cells in the numbered sheet.
+	load func(sheet int) ([][]string, error)
+
+	head []string
+	rows [][]string
+	pos  int
+
+	multiSplitter
+	headerInfo
+
+	stickyErr error
+}
+
+// NextSheet moves to the next Sheet in the document.
+func (x *sheetGrid) NextSheet() error {
+	if x.currentSheet+1 >= len(x.sheets) {
+		return io.EOF
+	}
+	x.selectSheet(x.currentSheet + 1)
+	return x.stickyErr
+}
+
+// Tables returns the names of all sheets in the document.
+// (Implements the formats.MultiTableReader interface)
+func (x *sheetGrid) Tables() []string {
+	return x.sheets
+}
+
+// Table returns the name of the current sheet.
+// (Implements the formats.MultiTableReader interface)
+func (x *sheetGrid) Table() string {
+	return x.sheets[x.currentSheet]
+}
+
+// SelectTable moves to the start of the named sheet.
+// (Implements the formats.MultiTableReader interface)
+func (x *sheetGrid) SelectTable(name string) error {
+	for i, s := range x.sheets {
+		if s == name {
+			x.selectSheet(i)
+			return x.stickyErr
+		}
+	}
+	return ErrUnknownTable
+}
+
+// NextTable moves to the next sheet in the document.
+// (Implements the formats.MultiTableReader interface)
+func (x *sheetGrid) NextTable() error {
+	return x.NextSheet()
+}
+
+func (x *sheetGrid) selectSheet(sheet int) {
+	x.currentSheet = sheet
+	x.head = nil
+	x.rows, x.stickyErr = x.load(sheet)
+	x.pos = 0
+	if x.stickyErr != nil {
+		return
+	}
+	x.skipHeaders()
+}
+
+func (x *sheetGrid) skipHeaders() {
+	// if there are descriptive lines etc at the top we try to skip over them
+	var rows [][]string
+	for _, row := range x.rows {
+		rows = append(rows, trimSheetRow(row))
+		if len(rows) > sheetHeaderCheckMaxRows {
+			break
+		}
+	}
+
+	row, confidence := findHeader(rows)
+	x.SetHeaderRow(row)
+	x.headConfidence = confidence
+}
+
+// SetHeaderRow moves to the start of the sheet and uses the indexed row
+// for field names, or generates field names if row is -1.
+// (Implements the formats.HeaderReader interface)
+func (x *sheetGrid) SetHeaderRow(row int) error {
+	if row < -1 {
+		return ErrHeaderRow
+	}
+	if row >= len(x.rows) {
+		return io.EOF
+	}
+	x.stickyErr = nil
+	x.headRow = row
+	x.headConfidence = 1
+	x.pos = row + 1
+	x.preamble = nil
+	if row > 0 {
+		x.preamble = sheetPreamble(x.rows[:row])
+	}
+
+	sample := x.rows[x.pos:]
+	if len(sample) > multiValueSampleRows {
+		sample = sample[:multiValueSampleRows]
+	}
+	if row == -1 {
+		x.head = generatedHeader(sample)
+	} else {
+		x.head = trimSheetRow(x.rows[row])
+	}
+	x.inferDelimiters(x.head, sample, "")
+	return nil
+}
+
+// Next returns the next Record in the document.
+// (Implements the formats.Reader interface)
+func (x *sheetGrid) Next() (Record, error) {
+	if x.stickyErr != nil {
+		return nil, x.stickyErr
+	}
+	if x.pos >= len(x.rows) {
+		x.stickyErr = io.EOF
+		return nil, x.stickyErr
+	}
+
+	cols := x.rows[x.pos]
+	x.pos++
+	if len(cols) > len(x.head) {
+		cols = cols[:len(x.head)]
+	}
+
+	return &simpleRec{
+		fields: x.head,
+		values: x.splitRow(x.head, cols),
+	}, nil
+}
+
+// Err returns the last error that occured.
+func (x *sheetGrid) Err() error {
+	return x.stickyErr
+}
@@ -10,8 +10,6 @@ import (
 const (
 	// check at most 5000 rows for header content
 	tsvHeaderCheckMaxRows = 5000
-
-	tsvMultiSplit = "|"
 )
 
 var (
@@ -37,6 +35,7 @@ func detectTSV(data []byte, incomplete bool) (supported, more bool) {
 		}
 	}()
 
+	data = decodeSample(data)
 	if incomplete {
 		idx := bytes.LastIndexByte(data, '\n')
 		if idx == -1 {
@@ -76,18 +75,30 @@ type TSV struct {
 	s *bufio.Scanner
 
 	head []string
+	enc  string
+
+	// records read ahead to infer multi-value delimiters
+	pending [][]string
+	multiSplitter
+	headerInfo
 
 	stickyErr error
 }
 
 // OpenTSV opens a TSV document and returns a formats.Reader.
 // An io.ReadSeeker is required due to header detection readahead.
+// Input that isn't UTF-8 is transcoded (see DetectEncoding).
 func OpenTSV(in io.ReadSeeker) (*TSV, error) {
+	in, enc, err := decodeText(in)
+	if err != nil {
+		return nil, err
+	}
 	r := bufio.NewScanner(in)
 
 	x := &TSV{
-		f: in,
-		s: r,
+		f:   in,
+		enc: enc,
+		s:   r,
 	}
 
 	x.skipHeaders()
@@ -97,21 +108,9 @@ func OpenTSV(in io.ReadSeeker) (*TSV, error) {
 
 func (x *TSV) skipHeaders() {
 	// if there are descriptive lines etc at the top we try to skip over them
-
-	// out of the first N rows, which number of columns is the most frequent?
-	bestcols := 0
-	nr := 0
-	colcounts := make(map[int]int)
-	for x.s.Scan() {
-		cols := strings.Split(x.s.Text(), "\t")
-		nr++
-		colcounts[len(cols)]++
-		if colcounts[len(cols)] > colcounts[bestcols] {
-			bestcols = len(cols)
-		}
-		if nr > tsvHeaderCheckMaxRows {
-			break
-		}
+	var rows [][]string
+	for len(rows) <= tsvHeaderCheckMaxRows && x.s.Scan() {
+		rows = append(rows, strings.Split(x.s.Text(), "\t"))
 	}
 	x.stickyErr = x.s.Err()
 	if x.stickyErr != nil {
@@ -120,50 +119,92 @@ func (x *TSV) skipHeaders() {
 
 	// TODO: verify that it actually looks like a TSV
 
+	row, confidence := findHeader(rows)
+	x.SetHeaderRow(row)
+	x.headConfidence = confidence
+}
+
+// SetHeaderRow moves to the start of the document and uses the indexed row
+// for field names, or generates field names if row is -1.
+// (Implements the formats.HeaderReader interface)
+func (x *TSV) SetHeaderRow(row int) error {
+	if row < -1 {
+		return ErrHeaderRow
+	}
 	_, x.stickyErr = x.f.Seek(0, io.SeekStart)
 	if x.stickyErr != nil {
-		return
+		return x.stickyErr
 	}
 	x.s = bufio.NewScanner(x.f)
-
-	// reset the row iterator and move until we hit first row with that number of columns
-	// assume that that row is the header (TODO: be smarter about this)
-	for x.s.Scan() {
-		cols := strings.Split(x.s.Text(), "\t")
-		if len(cols) == bestcols {
-			x.head = cols
-			return
+	x.head = nil
+	x.pending = nil
+	x.preamble = nil
+	x.headRow = row
+	x.headConfidence = 1
+
+	for i := 0; i <= row; i++ {
+		if !x.s.Scan() {
+			x.stickyErr = x.s.Err()
+			if x.stickyErr == nil {
+				x.stickyErr = io.EOF
+			}
+			return x.stickyErr
 		}
+		if i < row {
+			x.preamble = append(x.preamble, strings.TrimSuffix(x.s.Text(), "\r"))
+		}
+		x.head = strings.Split(x.s.Text(), "\t")
 	}
+	x.preamble = trimBlankLines(x.preamble)
+	x.readAhead()
+	if row == -1 {
+		x.head = generatedHeader(x.pending)
+	}
+	x.inferDelimiters(x.head, x.pending, "\t")
+	return x.stickyErr
+}
 
-	// TODO: autodetect per-column multi-value split delimiters
-	// tsvMultiSplit
-
+// readAhead reads a sample of records to infer multi-value delimiters.
+func (x *TSV) readAhead() {
+	for len(x.pending) < multiValueSampleRows && x.s.Scan() {
+		x.pending = append(x.pending, strings.Split(x.s.Text(), "\t"))
+	}
 	x.stickyErr = x.s.Err()
 }
 
 // Next returns the next Record in the document.
 // (Implements the formats.Reader interface)
 func (x *TSV) Next() (Record, error) {
-	if !x.s.Scan() {
-		x.stickyErr = x.s.Err()
-		if x.stickyErr == nil {
-			x.stickyErr = io.EOF
-		}
+	if x.stickyErr != nil {
 		return nil, x.stickyErr
 	}
-	cols := strings.Split(x.s.Text(), "\t")
-	vals := make([][]string, len(cols))
-	for i, c := range cols {
-		vals[i] = strings.Split(c, tsvMultiSplit)
+	var cols []string
+	if len(x.pending) > 0 {
+		cols = x.pending[0]
+		x.pending = x.pending[1:]
+	} else {
+		if !x.s.Scan() {
+			x.stickyErr = x.s.Err()
+			if x.stickyErr == nil {
+				x.stickyErr = io.EOF
+			}
+			return nil, x.stickyErr
+		}
+		cols = strings.Split(x.s.Text(), "\t")
 	}
 
 	return &simpleRec{
 		fields: x.head,
-		values: vals,
+		values: x.splitRow(x.head, cols),
 	}, nil
 }
 
+// Encoding returns the character encoding detected in the document.
+// (Implements the formats.EncodedReader interface)
+func (x *TSV) Encoding() string {
+	return x.enc
+}
+
 // Err returns the last error that occured.
 func (x *TSV) Err() error {
 	return x.stickyErr
@@ -0,0 +1,116 @@
+package formats
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/extrame/xls"
+)
+
+var (
+	_ = Register(&Format{
+		Name:        "Excel XLS",
+		Description: "Microsoft Excel 97-2003 Spreadsheet",
+		Extensions:  []string{".xls"},
+		MediaTypes:  []string{"application/vnd.ms-excel"},
+		Detect:      detectXLS,
+		NewReader: func(r io.Reader) (Reader, error) {
+			// TODO: this'll panic if necessary, but we could do it cleaner later
+			return OpenXLS(r.(io.ReadSeeker))
+		},
+		NewWriter: writerNotSupported,
+	})
+
+	// OLE2 compound document signature
+	ole2Magic = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+)
+
+func detectXLS(data []byte, incomplete bool) (supported, more bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			supported = false
+			more = false
+		}
+	}()
+
+	if incomplete {
+		// other Office 97-2003 documents use the same container
+		return bytes.HasPrefix(data, ole2Magic), true
+	}
+
+	wb, err := xls.OpenReader(bytes.NewReader(data), "utf-8")
+	return err == nil && wb != nil && wb.NumSheets() > 0, false
+}
+
+// XLS supports reading tabular records from a legacy (BIFF8) excel file.
+type XLS struct {
+	sheetGrid
+
+	wb *xls.WorkBook
+}
+
+// OpenXLS opens a legacy excel document and returns a formats.Reader.
+func OpenXLS(in io.ReadSeeker) (x *XLS, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			// the xls package panics on some malformed documents
+			x = nil
+			err = ErrUnsupportedFormat
+		}
+	}()
+
+	wb, err := xls.OpenReader(in, "utf-8")
+	if err != nil || wb == nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x = &XLS{wb: wb}
+	x.load = x.loadSheet
+	for i := 0; i < wb.NumSheets(); i++ {
+		x.sheets = append(x.sheets, wb.GetSheet(i).Name)
+	}
+	if len(x.sheets) == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	x.selectSheet(0)
+	return x, x.stickyErr
+}
+
+func (x *XLS) loadSheet(sheet int) (rows [][]string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			// the xls package panics on some malformed records
+			rows = nil
+			err = ErrUnsupportedFormat
+		}
+	}()
+
+	ws := x.wb.GetSheet(sheet)
+	if ws == nil {
+		return nil, io.EOF
+	}
+	for i := 0; i <= int(ws.MaxRow); i++ {
+		rows = append(rows, xlsRow(ws, i))
+	}
+	return rows, nil
+}
+
+// xlsRow returns the cells of a row, or nil for a row without any cells.
+func xlsRow(ws *xls.WorkSheet, i int) (cols []string) {
+	defer func() {
+		if e := recover(); e != nil {
+			// WorkSheet.Row panics when the row is not present
+			cols = nil
+		}
+	}()
+
+	row := ws.Row(i)
+	for j := row.FirstCol(); j < row.LastCol(); j++ {
+		for len(cols) < j {
+			cols = append(cols, "")
+		}
+		cols = append(cols, row.Col(j))
+	}
+	return cols
+}
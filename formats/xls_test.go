@@ -0,0 +1,63 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// malformedXLS returns a compound document whose root directory entry
+// claims a name longer than the entry can hold.
+func malformedXLS(nameSize uint16) []byte {
+	const (
+		endOfChain = 0xfffffffe
+		freeSect   = 0xffffffff
+		fatSect    = 0xfffffffd
+	)
+	le := binary.LittleEndian
+	doc := make([]byte, 3*512)
+
+	// header, with the allocation table in sector 0 and directory in sector 1
+	h := doc[:512]
+	copy(h, ole2Magic)
+	le.PutUint16(h[0x18:], 0x3e)
+	le.PutUint16(h[0x1a:], 3)
+	le.PutUint16(h[0x1c:], 0xfffe)
+	le.PutUint16(h[0x1e:], 9)
+	le.PutUint16(h[0x20:], 6)
+	le.PutUint32(h[0x2c:], 1)
+	le.PutUint32(h[0x30:], 1)
+	le.PutUint32(h[0x38:], 4096)
+	le.PutUint32(h[0x3c:], endOfChain)
+	le.PutUint32(h[0x44:], endOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(h[0x4c+4*i:], freeSect)
+	}
+	le.PutUint32(h[0x4c:], 0)
+
+	fat := doc[512:1024]
+	for i := 0; i < 128; i++ {
+		le.PutUint32(fat[4*i:], freeSect)
+	}
+	le.PutUint32(fat[0:], fatSect)
+	le.PutUint32(fat[4:], endOfChain)
+
+	root := doc[1024:1152]
+	for i, c := range utf16.Encode([]rune("Root Entry")) {
+		le.PutUint16(root[2*i:], c)
+	}
+	le.PutUint16(root[64:], nameSize)
+	root[66] = 5 // root storage
+	le.PutUint32(root[116:], endOfChain)
+	return doc
+}
+
+func TestOpenXLSMalformed(t *testing.T) {
+	// 22 is the correct size, but there is no workbook stream either
+	for _, size := range []uint16{0, 22, 110} {
+		if _, err := OpenXLS(bytes.NewReader(malformedXLS(size))); err != ErrUnsupportedFormat {
+			t.Errorf("name size %d: got %v, want ErrUnsupportedFormat", size, err)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package mapping
 
 import (
 	"crypto/sha256"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"log"
@@ -19,8 +18,9 @@ import (
 
 // Mapper handles data mapping/translation tasks.
 type Mapper struct {
-	pump chan request
-	src  *sources.Database
+	pump     chan request
+	src      *sources.Database
+	releases sources.Releases
 }
 
 // NewMapper starts a new background processor and returns
@@ -34,6 +34,12 @@ func NewMapper(s *sources.Database) *Mapper {
 	return m
 }
 
+// SetReleases sets the releases of the source database which can be
+// selected with Options.Release. It must be called before Start.
+func (m *Mapper) SetReleases(r sources.Releases) {
+	m.releases = r
+}
+
 type request struct {
 	inputFilename string
 	resultToken   string
@@ -59,8 +65,40 @@ type Options struct {
 	// dropped from output. If false, empty values are used.
 	DropMissing bool
 
-	// OutputFormat describes the requested output format.
+	// OutputFormat names the requested output format (see formats.Lookup).
 	OutputFormat string
+
+	// HeaderRow overrides the detected header row of the input tables that
+	// are translated, or -1 if there is no header row (see detection.Result).
+	HeaderRow *int
+
+	// Delimiters overrides the multi-value delimiter detected for the named
+	// input fields. An empty delimiter indicates a single-valued field.
+	Delimiters map[string]string
+
+	// KeepEncoding writes text output in the character encoding detected
+	// in the input, instead of UTF-8.
+	KeepEncoding bool
+
+	// Tables names the tables (e.g. sheets in a workbook) to translate when
+	// the input contains multiple tables. If empty, the first table is used.
+	// Other tables are copied unchanged if the output format supports
+	// multiple tables, otherwise only the first named table is output.
+	Tables []string
+
+	// Provenance appends a comment describing the translation to the
+	// preamble of each translated table, if the output format can
+	// contain one (see formats.PreambleWriter).
+	Provenance bool
+
+	// Release pins the translation to a named release of the source
+	// database (see Mapper.SetReleases), so that it can be reproduced
+	// exactly. If empty, the current source database is used.
+	Release string
+
+	// Filter restricts the mapping to the pairs of identifiers with
+	// matching attributes, e.g. a single taxon, if it is not nil.
+	Filter *sources.MappingFilter
 }
 
 // Result describes the mapping process and results.
@@ -77,7 +115,7 @@ type Result struct {
 	Methods string `json:"methods"`
 	// Citations contains a list of citations that match the Methods.
 	Citations []string `json:"citations"`
-	// NewFilename contains the filename for the output mapped CSV file.
+	// NewFilename contains the filename for the output mapped file.
 	NewFilename string `json:"newfilename"`
 
 	// Stats for how the mapping went.
@@ -153,20 +191,35 @@ func (m *Mapper) runOne(req request) {
 	res := &Result{Token: req.resultToken}
 	stats := &Stats{StartTime: time.Now()}
 	ext := filepath.Ext(req.inputFilename)
-	res.NewFilename = strings.Replace(req.inputFilename, ext, ".translated.csv", 1)
 
-	if opts.OutputFormat != "csv" {
+	outFormat, err := formats.Lookup(opts.OutputFormat)
+	if err != nil {
 		log.Println("stage0", req, req.options.OutputFormat)
 		databio.PutResult(req.resultToken, "mapping",
-			"error", "only csv output is currently supported")
+			"error", "unsupported output format")
 		return
 	}
+	res.NewFilename = strings.Replace(req.inputFilename, ext, ".translated"+outFormat.Extensions[0], 1)
+
+	src := m.src
+	if opts.Release != "" {
+		var ok bool
+		if src, ok = m.releases[opts.Release]; !ok {
+			log.Println("stage0", req, opts.Release)
+			databio.PutResult(req.resultToken, "mapping",
+				"error", "unknown source database release")
+			return
+		}
+	}
 
-	translator, err := m.src.GetMapper(opts.FromSource, opts.ToSource)
+	translator, err := src.GetFilteredMapper(opts.FromSource, opts.ToSource, opts.Filter)
 	if err != nil {
 		log.Println("stage0", req, err)
-		databio.PutResult(req.resultToken, "mapping",
-			"error", "unable to get translator")
+		msg := "unable to get translator"
+		if !opts.Filter.IsZero() {
+			msg = "unable to filter the mapping"
+		}
+		databio.PutResult(req.resultToken, "mapping", "error", msg)
 		return
 	}
 
@@ -194,85 +247,93 @@ func (m *Mapper) runOne(req request) {
 			"error", "unable to create output")
 		return
 	}
-	csvwr := csv.NewWriter(fout)
+	var out io.Writer = fout
+	var encw io.WriteCloser
+	if er, ok := r.(formats.EncodedReader); ok && opts.KeepEncoding && isText(outFormat) {
+		encw, err = formats.NewEncodingWriter(fout, er.Encoding())
+		if err != nil {
+			log.Println("stage3", req, err)
+			databio.PutResult(req.resultToken, "mapping",
+				"error", "unable to create output")
+			fout.Close()
+			return
+		}
+		out = encw
+	}
+	wr, err := newWriter(outFormat, out, r)
+	if err != nil {
+		log.Println("stage3", req, err)
+		databio.PutResult(req.resultToken, "mapping",
+			"error", "unable to create output")
+		fout.Close()
+		return
+	}
 
 	newFieldName := opts.FromField
 	if !opts.Replace {
-		newFieldName = m.src.Sources[opts.ToSource].Name
+		newFieldName = src.Sources[opts.ToSource].Name
 	}
-	first := true
-	rec, err := r.Next()
-	for err == nil {
-		missing := false
-		multiple := false
-		vals := rec.Values(opts.FromField)
-		stats.TotalRecords++
-		if len(vals) > 0 {
-			v2 := make([]string, 0, len(vals))
-			for _, v := range vals {
-				vx, ok := translator.Get(v)
-				if !ok || len(vx) == 0 {
-					missing = true
-					stats.SourceMissingValues++
-				}
-				if len(vx) > 1 {
-					multiple = true
-					stats.DestinationMultipleValues++
-					stats.DestinationMultipleNewCount += len(vx) - 1
-				}
-				v2 = append(v2, vx...)
-			}
-			rec.Set(newFieldName, v2)
-
-			if multiple {
-				stats.DestinationMultipleRecords++
-			}
-		}
-
-		if missing {
-			stats.SourceMissingRecords++
-			if opts.DropMissing {
-				rec, err = r.Next()
-				continue
-			}
-		}
-
-		if first {
-			first = false
-			err = csvwr.Write(rec.Fields())
-			if err != nil {
-				log.Println("stage4", req, err)
-				databio.PutResult(req.resultToken, "mapping",
-					"error", "unable to write header to output")
-				csvwr.Flush()
-				fout.Close()
-				return
-			}
+	tr := &translation{
+		opts:       opts,
+		stats:      stats,
+		translator: translator,
+		fieldName:  newFieldName,
+	}
+	if opts.Provenance {
+		comment := "#"
+		if dr, ok := r.(formats.DialectReader); ok && dr.Dialect().Comment != 0 {
+			comment = string(dr.Dialect().Comment)
 		}
+		tr.provenance = fmt.Sprintf("%s %s translated from %s to %s by Databio (https://datab.io) on %s",
+			comment, opts.FromField,
+			src.Sources[opts.FromSource].Description,
+			src.Sources[opts.ToSource].Description,
+			stats.StartTime.UTC().Format("2006-01-02"))
+	}
 
-		line := make([]string, len(rec.Fields()))
-		for i, v := range rec.Fields() {
-			line[i] = strings.Join(rec.Values(v), "|")
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	if mv, ok := r.(formats.MultiValueReader); ok {
+		for field, delim := range opts.Delimiters {
+			mv.SetDelimiter(field, delim)
 		}
-		err = csvwr.Write(line)
-		if err != nil {
-			log.Println("stage4", req, err)
-			databio.PutResult(req.resultToken, "mapping",
-				"error", "unable to write to output")
-			csvwr.Flush()
-			fout.Close()
-			return
+	}
+	if mr, ok := r.(formats.MultiTableReader); ok {
+		err = tr.runTables(mr, wr)
+	} else {
+		err = tr.run(r, wr)
+	}
+	if _, ok := err.(outputError); ok {
+		log.Println("stage4", req, err)
+		databio.PutResult(req.resultToken, "mapping",
+			"error", "unable to write to output")
+		wr.Close()
+		fout.Close()
+		return
+	}
+	if err == formats.ErrUnknownTable {
+		log.Println("stage2", req, err)
+		databio.PutResult(req.resultToken, "mapping",
+			"error", "unknown table in input")
+		wr.Close()
+		fout.Close()
+		return
+	}
+	if err == nil {
+		err = wr.Close()
+	}
+	if encw != nil {
+		if cerr := encw.Close(); cerr != nil && err == nil {
+			err = cerr
 		}
-
-		rec, err = r.Next()
 	}
-	csvwr.Flush()
 	fout.Sync()
 	uploadInfo, _ := f.Stat()
 	convertedInfo, _ := fout.Stat()
 	fout.Close()
 
-	if err != io.EOF {
+	if err != nil {
 		log.Println("stage99", req, err)
 		databio.PutResult(req.resultToken, "mapping",
 			"error", "unable to translate")
@@ -284,26 +345,30 @@ func (m *Mapper) runOne(req request) {
 	res.Stats = stats
 
 	fmtArgs := []interface{}{
-		m.src.Sources[opts.FromSource].Description, 1,
-		m.src.Sources[opts.ToSource].Description, 2,
+		src.Sources[opts.FromSource].Description, 1,
+		src.Sources[opts.ToSource].Description, 2,
 		3,
 	}
 	res.Methods = "Source identifiers were recognized as %ss [%d], and were " +
 		"converted to %ss [%d] using the Databio tools [%d]. "
 
-	t1 := m.src.Sources[opts.FromSource].LastUpdate
-	t2 := m.src.Sources[opts.ToSource].LastUpdate
+	t1 := src.Sources[opts.FromSource].LastUpdate
+	t2 := src.Sources[opts.ToSource].LastUpdate
 
 	uploadSize := fmt.Sprintf("(%d byte %s)", uploadInfo.Size(), filepath.Ext(uploadInfo.Name()))
 	convertedSize := fmt.Sprintf("(%d byte %s)", convertedInfo.Size(), filepath.Ext(convertedInfo.Name()))
 
 	logs := []string{
 		"- date/times in UTC - Processed using data integration tools at https://datab.io",
-		t1.Format("2006-01-02 15:04:05") + " - Data fetched for " + m.src.Sources[opts.FromSource].Description,
-		t2.Format("2006-01-02 15:04:05") + " - Data fetched for " + m.src.Sources[opts.ToSource].Description,
+		t1.Format("2006-01-02 15:04:05") + " - Data fetched for " + src.Sources[opts.FromSource].Description,
+		t2.Format("2006-01-02 15:04:05") + " - Data fetched for " + src.Sources[opts.ToSource].Description,
 		uploadInfo.ModTime().UTC().Format("2006-01-02 15:04:05") + " - Source data uploaded to Databio " + uploadSize,
 		convertedInfo.ModTime().UTC().Format("2006-01-02 15:04:05") + " - Data mapping completed " + convertedSize,
 	}
+	if rel := src.Release; rel != nil {
+		logs = append(logs, rel.Created.UTC().Format("2006-01-02 15:04:05")+
+			" - Source database release "+rel.ID+" created")
+	}
 	sort.Strings(logs)
 
 	if t2.Before(t1) {
@@ -327,26 +392,273 @@ func (m *Mapper) runOne(req request) {
 			"expanded to include multiple associated %ss each. "
 		fmtArgs = append(fmtArgs, stats.DestinationMultipleRecords, stats.TotalRecords,
 			float64(stats.DestinationMultipleRecords)*100.0/float64(stats.TotalRecords),
-			m.src.Sources[opts.FromSource].Description,
-			m.src.Sources[opts.ToSource].Description)
+			src.Sources[opts.FromSource].Description,
+			src.Sources[opts.ToSource].Description)
+	}
+
+	if !opts.Filter.IsZero() {
+		res.Methods += "Only the mapping data with %s was used. "
+		fmtArgs = append(fmtArgs, opts.Filter.String())
+	}
+
+	if src.Release != nil {
+		res.Methods += "Identifiers were mapped using release %s of the Databio source database. "
+		fmtArgs = append(fmtArgs, src.Release.ID)
 	}
 
 	fmtArgs = append(fmtArgs,
-		m.src.Sources[opts.FromSource].Cite(),
-		m.src.Sources[opts.ToSource].Cite(),
+		src.Sources[opts.FromSource].Cite(),
+		src.Sources[opts.ToSource].Cite(),
 		databioCitations[0])
 	res.Methods += "\n\n  1. %s\n  2. %s\n  3. %s"
 	res.Methods = fmt.Sprintf(res.Methods, fmtArgs...)
 
 	res.Citations = []string{
-		m.src.Sources[opts.FromSource].Citation,
-		m.src.Sources[opts.ToSource].Citation,
+		src.Sources[opts.FromSource].Citation,
+		src.Sources[opts.ToSource].Citation,
 		databioCitations[1],
 	}
 	res.Log = strings.Join(logs, "\n")
 	databio.PutResult(req.resultToken, "mapping", res)
 }
 
+// outputError indicates a failure to write to the output.
+type outputError struct {
+	error
+}
+
+// translation holds the state of a single mapping task.
+type translation struct {
+	opts       *Options
+	stats      *Stats
+	translator sources.Mapper
+	fieldName  string
+
+	// comment line appended to the preamble of translated tables
+	provenance string
+}
+
+// runTables translates the selected tables of a multi-table input. If
+// the output supports multiple tables, the other tables are copied as-is.
+func (t *translation) runTables(r formats.MultiTableReader, wr formats.Writer) error {
+	selected := make(map[string]bool)
+	for _, name := range t.opts.Tables {
+		selected[name] = true
+	}
+	known := 0
+	for _, name := range r.Tables() {
+		if selected[name] {
+			known++
+		}
+	}
+	if known < len(selected) {
+		return formats.ErrUnknownTable
+	}
+	if len(selected) == 0 {
+		selected[r.Table()] = true
+	}
+
+	mw, ok := wr.(formats.MultiTableWriter)
+	if !ok {
+		// only one table can be output
+		if len(t.opts.Tables) > 0 {
+			if err := r.SelectTable(t.opts.Tables[0]); err != nil {
+				return err
+			}
+		}
+		return t.run(r, wr)
+	}
+
+	for i, name := range r.Tables() {
+		if i > 0 {
+			if err := r.NextTable(); err != nil {
+				return err
+			}
+		}
+		if err := mw.BeginTable(name); err != nil {
+			return outputError{err}
+		}
+
+		var err error
+		if selected[name] {
+			err = t.run(r, wr)
+		} else {
+			copyPreamble(r, wr, "")
+			copySchema(r, wr, "")
+			err = copyRecords(r, wr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordBatchSize is the number of records whose identifiers are looked up
+// together.
+const recordBatchSize = 1000
+
+// run translates the records of r into wr.
+func (t *translation) run(r formats.Reader, wr formats.Writer) error {
+	opts := t.opts
+	if hr, ok := r.(formats.HeaderReader); ok && opts.HeaderRow != nil {
+		if err := hr.SetHeaderRow(*opts.HeaderRow); err != nil {
+			return err
+		}
+	}
+	copyPreamble(r, wr, t.provenance)
+	copySchema(r, wr, t.fieldName)
+	batch := make([]formats.Record, 0, recordBatchSize)
+	for {
+		rec, err := r.Next()
+		if err == nil {
+			batch = append(batch, rec)
+		}
+		if len(batch) == cap(batch) || (err != nil && len(batch) > 0) {
+			if werr := t.translate(batch, wr); werr != nil {
+				return werr
+			}
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// translate looks up the identifiers of a batch of records at once, then
+// writes the translated records into wr.
+func (t *translation) translate(batch []formats.Record, wr formats.Writer) error {
+	opts, stats := t.opts, t.stats
+	var ids []string
+	for _, rec := range batch {
+		ids = append(ids, rec.Values(opts.FromField)...)
+	}
+	mapped := t.translator.GetMany(ids)
+
+	for _, rec := range batch {
+		missing := false
+		multiple := false
+		vals := rec.Values(opts.FromField)
+		stats.TotalRecords++
+		if len(vals) > 0 {
+			v2 := make([]string, 0, len(vals))
+			for _, v := range vals {
+				vx, ok := mapped[v]
+				if !ok || len(vx) == 0 {
+					missing = true
+					stats.SourceMissingValues++
+				}
+				if len(vx) > 1 {
+					multiple = true
+					stats.DestinationMultipleValues++
+					stats.DestinationMultipleNewCount += len(vx) - 1
+				}
+				v2 = append(v2, vx...)
+			}
+			rec.Set(t.fieldName, v2)
+
+			if multiple {
+				stats.DestinationMultipleRecords++
+			}
+		}
+
+		if missing {
+			stats.SourceMissingRecords++
+			if opts.DropMissing {
+				continue
+			}
+		}
+
+		if err := wr.Write(rec); err != nil {
+			return outputError{err}
+		}
+	}
+	return nil
+}
+
+// copyPreamble passes the lines above the header row of r to wr, with the
+// provenance comment appended if it isn't empty.
+func copyPreamble(r formats.Reader, wr formats.Writer, provenance string) {
+	pw, ok := wr.(formats.PreambleWriter)
+	if !ok {
+		return
+	}
+	var lines []string
+	if pr, ok := r.(formats.PreambleReader); ok {
+		lines = append(lines, pr.Preamble()...)
+	}
+	if provenance != "" {
+		lines = append(lines, provenance)
+	}
+	pw.SetPreamble(lines)
+}
+
+// copySchema passes the schema of r to wr, except that the named field,
+// which will contain translated identifiers, is always a string field.
+func copySchema(r formats.Reader, wr formats.Writer, translated string) {
+	sw, ok := wr.(formats.SchemaWriter)
+	if !ok {
+		return
+	}
+	var schema formats.Schema
+	if sr, ok := r.(formats.SchemaReader); ok {
+		for _, f := range sr.Schema() {
+			if f.Name != translated {
+				schema = append(schema, f)
+			}
+		}
+	}
+	if translated != "" {
+		schema = append(schema, &formats.Field{Name: translated, Type: formats.TypeString})
+	}
+	if schema != nil {
+		sw.SetSchema(schema)
+	}
+}
+
+// copyRecords writes the records of r to wr unchanged.
+func copyRecords(r formats.Reader, wr formats.Writer) error {
+	rec, err := r.Next()
+	for err == nil {
+		if err = wr.Write(rec); err != nil {
+			return outputError{err}
+		}
+		rec, err = r.Next()
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// isText is true if the output format is a text format, and can thus
+// be written in a different character encoding.
+func isText(outFormat *formats.Format) bool {
+	for _, mt := range outFormat.MediaTypes {
+		if strings.HasPrefix(mt, "text/") {
+			return true
+		}
+	}
+	return false
+}
+
+// newWriter returns a formats.Writer for the output format. CSV output
+// uses the same dialect as the input where possible.
+func newWriter(outFormat *formats.Format, w io.Writer, r formats.Reader) (formats.Writer, error) {
+	if outFormat.Name == "CSV" {
+		d := formats.DefaultDialect
+		if dr, ok := r.(formats.DialectReader); ok {
+			d = dr.Dialect()
+		}
+		return formats.NewCSVWriter(w, d), nil
+	}
+	return outFormat.NewWriter(w)
+}
+
 // FIXME: publish and swap out the preprint
 var databioCitations = []string{
 	`Reid et al. "Automated Gene Data Integration with Databio" BMC Res Notes. (2020).`,
@@ -0,0 +1,73 @@
+package mapping
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/joiningdata/databio/formats"
+)
+
+// schemaRecorder is a formats.SchemaWriter which keeps the schema.
+type schemaRecorder struct {
+	formats.Writer
+	schema formats.Schema
+}
+
+func (w *schemaRecorder) SetSchema(s formats.Schema) {
+	w.schema = s
+}
+
+func openTemp(t *testing.T, ext, content string) formats.Reader {
+	t.Helper()
+	f, err := ioutil.TempFile("", "databio-test-*"+ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r, err := formats.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCopySchemaTranslated(t *testing.T) {
+	for _, tc := range []struct {
+		name, ext, content string
+	}{
+		{"schema reader", ".csv", "gene,score\nA1,1\nA2,2\nA3,3\n"},
+		{"no schema", ".ndjson", `{"gene":"A1","score":1}` + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := openTemp(t, tc.ext, tc.content)
+			w := &schemaRecorder{}
+			copySchema(r, w, "gene")
+			if got := w.schema.ValueType("gene"); got != formats.TypeString {
+				t.Errorf("translated field type = %q, want %q", got, formats.TypeString)
+			}
+			if f := w.schema.Field("gene"); f == nil || f.Type != formats.TypeString {
+				t.Errorf("translated field = %+v, want a string field", f)
+			}
+		})
+	}
+
+	r := openTemp(t, ".csv", "gene,score\nA1,1\nA2,2\n")
+	w := &schemaRecorder{}
+	copySchema(r, w, "entrez")
+	if got := w.schema.ValueType("score"); got != formats.TypeInt {
+		t.Errorf("score type = %q, want %q", got, formats.TypeInt)
+	}
+	if got := w.schema.ValueType("entrez"); got != formats.TypeString {
+		t.Errorf("new translated field type = %q, want %q", got, formats.TypeString)
+	}
+}
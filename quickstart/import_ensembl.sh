@@ -15,9 +15,10 @@ $IMP ref org.ensembl.protein ensembl.ris
 
 
 
-$IMP index org.ensembl.gene ensembl_genes.txt
-$IMP index org.ensembl.transcript ensembl_transcripts.txt
-$IMP index org.ensembl.protein ensembl_proteins.txt
+# the identifiers come from the NCBI and HGNC downloads
+$IMP -c Ensembl_gene_identifier    update index org.ensembl.gene gene2ensembl.gz
+$IMP -c Ensembl_rna_identifier     update index org.ensembl.transcript gene2ensembl.gz
+$IMP -c Ensembl_protein_identifier update index org.ensembl.protein gene2ensembl.gz
 
 ### subset indexes
-$IMP -s human index org.ensembl.gene hgnc_ensgene.txt
+$IMP -c "Ensembl gene ID" -s human update index org.ensembl.gene hgnc.tsv
@@ -29,30 +29,28 @@ for org in aga ath bta cel cfa dme dre eco ecs gga hsa mmu mcc pfa ptr rno sce s
 	sleep 1
 done
 
-cut -f1 uniprot2kegg_genes.tsv >uniprot_accessions.txt
-
 # give slightly friendlier names than the 3-letter codes
-$IMP -d $STAMP -s "Anopheles"  index jp.kegg.gene kegg_genes_aga.txt
-$IMP -d $STAMP -s "Arabidopsis"  index jp.kegg.gene kegg_genes_ath.txt
-$IMP -d $STAMP -s "Bovine"  index jp.kegg.gene kegg_genes_bta.txt
-$IMP -d $STAMP -s "Worm"  index jp.kegg.gene kegg_genes_cel.txt
-$IMP -d $STAMP -s "Canine"  index jp.kegg.gene kegg_genes_cfa.txt
-$IMP -d $STAMP -s "Fly"  index jp.kegg.gene kegg_genes_dme.txt
-$IMP -d $STAMP -s "Zebrafish"  index jp.kegg.gene kegg_genes_dre.txt
-$IMP -d $STAMP -s "E coli strain K12"  index jp.kegg.gene kegg_genes_eco.txt
-$IMP -d $STAMP -s "E coli strain Sakai"  index jp.kegg.gene kegg_genes_ecs.txt
-$IMP -d $STAMP -s "Chicken"  index jp.kegg.gene kegg_genes_gga.txt
-$IMP -d $STAMP -s "Human"  index jp.kegg.gene kegg_genes_hsa.txt
-$IMP -d $STAMP -s "Mouse"  index jp.kegg.gene kegg_genes_mmu.txt
-$IMP -d $STAMP -s "Rhesus"  index jp.kegg.gene kegg_genes_mcc.txt
-$IMP -d $STAMP -s "Malaria"  index jp.kegg.gene kegg_genes_pfa.txt
-$IMP -d $STAMP -s "Chimp"  index jp.kegg.gene kegg_genes_ptr.txt
-$IMP -d $STAMP -s "Rat"  index jp.kegg.gene kegg_genes_rno.txt
-$IMP -d $STAMP -s "Yeast"  index jp.kegg.gene kegg_genes_sce.txt
-$IMP -d $STAMP -s "Pig"  index jp.kegg.gene kegg_genes_ssc.txt
-$IMP -d $STAMP -s "Xenopus"  index jp.kegg.gene kegg_genes_xla.txt
-
-$IMP -d $STAMP index org.uniprot.acc uniprot_accessions.txt
-
-$IMP -d $STAMP map gov.nih.nlm.ncbi.gene jp.kegg.gene ncbi_gene2kegg_genes.tsv
-$IMP -d $STAMP map org.uniprot.acc jp.kegg.gene uniprot2kegg_genes.tsv
\ No newline at end of file
+$IMP -d $STAMP -s "Anopheles"  update index jp.kegg.gene kegg_genes_aga.txt
+$IMP -d $STAMP -s "Arabidopsis"  update index jp.kegg.gene kegg_genes_ath.txt
+$IMP -d $STAMP -s "Bovine"  update index jp.kegg.gene kegg_genes_bta.txt
+$IMP -d $STAMP -s "Worm"  update index jp.kegg.gene kegg_genes_cel.txt
+$IMP -d $STAMP -s "Canine"  update index jp.kegg.gene kegg_genes_cfa.txt
+$IMP -d $STAMP -s "Fly"  update index jp.kegg.gene kegg_genes_dme.txt
+$IMP -d $STAMP -s "Zebrafish"  update index jp.kegg.gene kegg_genes_dre.txt
+$IMP -d $STAMP -s "E coli strain K12"  update index jp.kegg.gene kegg_genes_eco.txt
+$IMP -d $STAMP -s "E coli strain Sakai"  update index jp.kegg.gene kegg_genes_ecs.txt
+$IMP -d $STAMP -s "Chicken"  update index jp.kegg.gene kegg_genes_gga.txt
+$IMP -d $STAMP -s "Human"  update index jp.kegg.gene kegg_genes_hsa.txt
+$IMP -d $STAMP -s "Mouse"  update index jp.kegg.gene kegg_genes_mmu.txt
+$IMP -d $STAMP -s "Rhesus"  update index jp.kegg.gene kegg_genes_mcc.txt
+$IMP -d $STAMP -s "Malaria"  update index jp.kegg.gene kegg_genes_pfa.txt
+$IMP -d $STAMP -s "Chimp"  update index jp.kegg.gene kegg_genes_ptr.txt
+$IMP -d $STAMP -s "Rat"  update index jp.kegg.gene kegg_genes_rno.txt
+$IMP -d $STAMP -s "Yeast"  update index jp.kegg.gene kegg_genes_sce.txt
+$IMP -d $STAMP -s "Pig"  update index jp.kegg.gene kegg_genes_ssc.txt
+$IMP -d $STAMP -s "Xenopus"  update index jp.kegg.gene kegg_genes_xla.txt
+
+$IMP -d $STAMP -c 1 update index org.uniprot.acc uniprot2kegg_genes.tsv
+
+$IMP -d $STAMP update map gov.nih.nlm.ncbi.gene jp.kegg.gene ncbi_gene2kegg_genes.tsv
+$IMP -d $STAMP update map org.uniprot.acc jp.kegg.gene uniprot2kegg_genes.tsv
\ No newline at end of file
@@ -19,7 +19,7 @@ grep 'gene	' mim2gene.txt|cut -f 1,5 |grep -v '\t$' >omim_gene2ensembl.tsv
 #############################################
 
 # load the index data and source mappings
-$IMP -d $STAMP index org.omim.gene omim_genes.txt
+$IMP -d $STAMP update index org.omim.gene omim_genes.txt
 
-$IMP -d $STAMP map org.omim.gene gov.nih.nlm.ncbi.gene omim_gene2entrez.txt
-$IMP -d $STAMP map org.omim.gene org.ensembl.gene omim_gene2ensembl.txt
+$IMP -d $STAMP update map org.omim.gene gov.nih.nlm.ncbi.gene omim_gene2entrez.txt
+$IMP -d $STAMP update map org.omim.gene org.ensembl.gene omim_gene2ensembl.txt
@@ -0,0 +1,191 @@
+package sources
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pair attributes are optional columns of a mapping table which describe
+// each pair of identifiers.
+const (
+	// AttrRelationship is the type of relationship, e.g. "primary".
+	AttrRelationship = "relationship"
+
+	// AttrEvidence is the evidence for, or the source of, the pair.
+	AttrEvidence = "evidence"
+
+	// AttrTaxon is the taxon of the pair, e.g. "9606".
+	AttrTaxon = "taxon"
+
+	// AttrRank orders the pairs of an identifier, with 1 the best.
+	AttrRank = "rank"
+)
+
+// PairAttributes lists the supported pair attributes.
+var PairAttributes = []string{AttrRelationship, AttrEvidence, AttrTaxon, AttrRank}
+
+// MappingFilter restricts a mapping to the pairs with matching attributes.
+// Blank fields don't filter.
+type MappingFilter struct {
+	// Relationship type of the pairs, e.g. "primary".
+	Relationship string
+
+	// Evidence for, or source of, the pairs.
+	Evidence string
+
+	// Taxon of the pairs, e.g. "9606".
+	Taxon string
+
+	// MaxRank keeps the pairs ranked MaxRank or better, e.g. 1 for the
+	// primary links only, if it is more than 0.
+	MaxRank int
+}
+
+// IsZero is true if the filter doesn't restrict the mapping.
+func (f *MappingFilter) IsZero() bool {
+	return f == nil || *f == MappingFilter{}
+}
+
+// conditions returns the attributes and values which must match.
+func (f *MappingFilter) conditions() (attrs []string, args []interface{}) {
+	for _, c := range []struct {
+		attr  string
+		value string
+	}{
+		{AttrRelationship, f.Relationship},
+		{AttrEvidence, f.Evidence},
+		{AttrTaxon, f.Taxon},
+	} {
+		if c.value != "" {
+			attrs = append(attrs, c.attr)
+			args = append(args, c.value)
+		}
+	}
+	if f.MaxRank > 0 {
+		attrs = append(attrs, AttrRank)
+		args = append(args, f.MaxRank)
+	}
+	return attrs, args
+}
+
+func (f *MappingFilter) String() string {
+	var parts []string
+	if f.Relationship != "" {
+		parts = append(parts, "relationship "+f.Relationship)
+	}
+	if f.Evidence != "" {
+		parts = append(parts, "evidence "+f.Evidence)
+	}
+	if f.Taxon != "" {
+		parts = append(parts, "taxon "+f.Taxon)
+	}
+	if f.MaxRank > 0 {
+		parts = append(parts, fmt.Sprintf("rank %d or better", f.MaxRank))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// mappingTable describes the table of a mapping in one direction.
+type mappingTable struct {
+	name     string
+	from, to string
+
+	// attrs are the pair attribute columns of the table.
+	attrs map[string]bool
+}
+
+// batchQuery returns a query of the pairs of mapperBatchSize identifiers,
+// with the given conditions on the pair attributes.
+func (t *mappingTable) batchQuery(conds []string) string {
+	params := strings.TrimSuffix(strings.Repeat("?,", mapperBatchSize), ",")
+	where := append([]string{t.from + " IN (" + params + ")"}, conds...)
+	return fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s;", t.from, t.to, t.name, strings.Join(where, " AND "))
+}
+
+// readPairAttributes finds the pair attribute columns of each mapping table.
+func readPairAttributes(sdb *sql.DB, tables map[string]map[string]*mappingTable) error {
+	found := make(map[string]map[string]bool)
+	for _, m := range tables {
+		for _, t := range m {
+			if attrs, ok := found[t.name]; ok {
+				t.attrs = attrs
+				continue
+			}
+			rows, err := sdb.Query(`SELECT name FROM pragma_table_info(?);`, t.name)
+			if err != nil {
+				return err
+			}
+			t.attrs = make(map[string]bool)
+			for rows.Next() {
+				var col string
+				if err = rows.Scan(&col); err != nil {
+					rows.Close()
+					return err
+				}
+				for _, attr := range PairAttributes {
+					if col == attr {
+						t.attrs[attr] = true
+					}
+				}
+			}
+			rows.Close()
+			found[t.name] = t.attrs
+		}
+	}
+	return nil
+}
+
+// MappingAttributes returns the pair attributes that a mapping between
+// the given sources can be filtered by.
+func (x *Database) MappingAttributes(fromID, toID string) []string {
+	t, ok := x.tables[fromID][toID]
+	if !ok {
+		return nil
+	}
+	var res []string
+	for _, attr := range PairAttributes {
+		if t.attrs[attr] {
+			res = append(res, attr)
+		}
+	}
+	return res
+}
+
+// GetFilteredMapper returns a mapper from the given source IDs to another
+// source IDs, using only the pairs which match the filter.
+func (x *Database) GetFilteredMapper(fromID, toID string, f *MappingFilter) (Mapper, error) {
+	if f.IsZero() {
+		return x.GetMapper(fromID, toID)
+	}
+	t, ok := x.tables[fromID][toID]
+	if !ok {
+		return nil, errors.New("databio/sources: no supported mapping")
+	}
+	attrs, args := f.conditions()
+	var conds []string
+	for _, attr := range attrs {
+		if !t.attrs[attr] {
+			return nil, fmt.Errorf("databio/sources: mapping has no %s attribute", attr)
+		}
+		if attr == AttrRank {
+			conds = append(conds, attr+"<=?")
+		} else {
+			conds = append(conds, attr+"=?")
+		}
+	}
+	where := append([]string{t.from + "=?"}, conds...)
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s;", t.to, t.name, strings.Join(where, " AND "))
+
+	key := fmt.Sprintf("%s %#v", q, args)
+	if m, ok := x.mappers[key]; ok {
+		return m, nil
+	}
+	m, err := newDBMapper(x.db, q, t.batchQuery(conds), args)
+	if err != nil {
+		return nil, err
+	}
+	x.mappers[key] = m
+	return m, nil
+}
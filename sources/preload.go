@@ -0,0 +1,200 @@
+package sources
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// OpenOptions controls how a source database is loaded.
+type OpenOptions struct {
+	// Preload lists the mappings to hold in memory, as pairs of source
+	// names in either order.
+	Preload [][2]string
+
+	// PreloadAll holds every mapping in memory, as far as MaxPreloadBytes
+	// allows.
+	PreloadAll bool
+
+	// MaxPreloadBytes limits the approximate memory used by the preloaded
+	// mappings of each database, if it is more than 0. Mappings which don't
+	// fit are looked up in the database as usual.
+	MaxPreloadBytes int64
+}
+
+// Approximate memory used by the preloaded mappings, in bytes.
+const (
+	// stringOverhead is the size of a string header, or a slice element.
+	stringOverhead = 16
+
+	// mapEntryOverhead is the size of a map key, its slice header and
+	// the map's bookkeeping.
+	mapEntryOverhead = 64
+)
+
+var errPreloadLimit = errors.New("databio/sources: preload memory limit exceeded")
+
+// memMapper is a one-way mapping held in memory. The identifier slices are
+// shared, and must not be modified.
+type memMapper struct {
+	pairs map[string][]string
+}
+
+func (m *memMapper) Get(leftID string) (rightIDs []string, found bool) {
+	return m.pairs[leftID], true
+}
+
+func (m *memMapper) GetMany(leftIDs []string) map[string][]string {
+	res := make(map[string][]string, len(leftIDs))
+	for _, id := range leftIDs {
+		res[id] = m.pairs[id]
+	}
+	return res
+}
+
+// preloadedMapping is a mapping table selected for preloading.
+type preloadedMapping struct {
+	left, right string
+	table       *mappingTable
+}
+
+// PreloadedBytes returns the approximate memory used by the mappings held
+// in memory.
+func (x *Database) PreloadedBytes() int64 {
+	return x.preloadBytes
+}
+
+// preload loads the mappings selected by opts into memory, in both
+// directions.
+func (x *Database) preload(opts *OpenOptions) error {
+	x.preloaded = make(map[string]map[string]*memMapper)
+	if opts == nil {
+		return nil
+	}
+
+	var selected []preloadedMapping
+	seen := make(map[string]bool)
+	add := func(a, b string) error {
+		t, ok := x.tables[a][b]
+		if !ok && x.Release != nil {
+			// the snapshot may predate the mapping
+			log.Printf("%s<>%s :: not in release %s, not preloaded", a, b, x.Release.ID)
+			return nil
+		}
+		if !ok {
+			return fmt.Errorf("databio/sources: no mapping between %s and %s to preload", a, b)
+		}
+		if t.from != "left_id" {
+			a, b = b, a
+		}
+		if !seen[t.name] {
+			seen[t.name] = true
+			selected = append(selected, preloadedMapping{left: a, right: b, table: x.tables[a][b]})
+		}
+		return nil
+	}
+	for _, p := range opts.Preload {
+		if err := add(p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	if opts.PreloadAll {
+		var lefts []string
+		for left := range x.tables {
+			lefts = append(lefts, left)
+		}
+		sort.Strings(lefts)
+		for _, left := range lefts {
+			var rights []string
+			for right := range x.tables[left] {
+				rights = append(rights, right)
+			}
+			sort.Strings(rights)
+			for _, right := range rights {
+				if err := add(left, right); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, p := range selected {
+		limit := int64(0)
+		if opts.MaxPreloadBytes > 0 {
+			limit = opts.MaxPreloadBytes - x.preloadBytes
+			if limit <= 0 {
+				log.Printf("%s<>%s :: preload memory limit reached, left in the database", p.left, p.right)
+				continue
+			}
+		}
+		lr, rl, size, err := loadMapping(x.db, p.table, limit)
+		if err == errPreloadLimit {
+			log.Printf("%s<>%s :: exceeds the preload memory limit, left in the database", p.left, p.right)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s<>%s: %v", p.left, p.right, err)
+		}
+		if _, ok := x.preloaded[p.left]; !ok {
+			x.preloaded[p.left] = make(map[string]*memMapper)
+		}
+		if _, ok := x.preloaded[p.right]; !ok {
+			x.preloaded[p.right] = make(map[string]*memMapper)
+		}
+		x.preloaded[p.left][p.right] = lr
+		x.preloaded[p.right][p.left] = rl
+		x.preloadBytes += size
+		log.Printf("%s<>%s :: %d+%d identifiers preloaded (%.1f MB)", p.left, p.right,
+			len(lr.pairs), len(rl.pairs), float64(size)/(1<<20))
+	}
+	return nil
+}
+
+// loadMapping reads the pairs of a mapping table into a mapper for each
+// direction, and returns their approximate size. Identifiers are shared
+// between the directions. If limit is more than 0 and the size exceeds
+// it, errPreloadLimit is returned.
+func loadMapping(sdb *sql.DB, t *mappingTable, limit int64) (lr, rl *memMapper, size int64, err error) {
+	rows, err := sdb.Query("SELECT left_id,right_id FROM " + t.name + ";")
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer rows.Close()
+
+	strs := make(map[string]string)
+	intern := func(s string) string {
+		if v, ok := strs[s]; ok {
+			return v
+		}
+		strs[s] = s
+		size += int64(len(s)) + stringOverhead
+		return s
+	}
+	lr = &memMapper{pairs: make(map[string][]string)}
+	rl = &memMapper{pairs: make(map[string][]string)}
+	for rows.Next() {
+		var l, r string
+		if err = rows.Scan(&l, &r); err != nil {
+			return nil, nil, 0, err
+		}
+		l, r = intern(l), intern(r)
+		if _, ok := lr.pairs[l]; !ok {
+			size += mapEntryOverhead
+		}
+		if _, ok := rl.pairs[r]; !ok {
+			size += mapEntryOverhead
+		}
+		lr.pairs[l] = append(lr.pairs[l], r)
+		rl.pairs[r] = append(rl.pairs[r], l)
+		size += 2 * stringOverhead
+		if limit > 0 && size > limit {
+			return nil, nil, size, errPreloadLimit
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+	return lr, rl, size, nil
+}
@@ -0,0 +1,111 @@
+package sources
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPreloadMatchesDatabase(t *testing.T) {
+	filename := createTestDB(t, t.TempDir(), "")
+	plain, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, err := OpenWithOptions(filename, &OpenOptions{PreloadAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.PreloadedBytes() == 0 {
+		t.Fatal("nothing preloaded")
+	}
+
+	var ids []string
+	for i := 0; i < 3100; i++ {
+		ids = append(ids, fmt.Sprintf("L%d", i), fmt.Sprintf("R%d_%d", i, i%3), fmt.Sprintf("C%d", i))
+	}
+	for _, dir := range [][2]string{{"a.src", "b.src"}, {"b.src", "a.src"}, {"a.src", "c.src"}, {"c.src", "a.src"}} {
+		m, err := mem.GetMapper(dir[0], dir[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.(*memMapper); !ok {
+			t.Fatalf("%s to %s is a %T, want a preloaded mapper", dir[0], dir[1], m)
+		}
+		m1, err := plain.GetMapper(dir[0], dir[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, want := m.GetMany(ids), m1.GetMany(ids)
+		for _, id := range ids {
+			if len(got[id])+len(want[id]) > 0 && !reflect.DeepEqual(sortedIDs(got[id]), sortedIDs(want[id])) {
+				t.Fatalf("%s to %s: %q = %q, want %q", dir[0], dir[1], id, got[id], want[id])
+			}
+		}
+	}
+
+	// filters aren't preloaded
+	m, err := mem.GetFilteredMapper("a.src", "b.src", &MappingFilter{MaxRank: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*dbMapper); !ok {
+		t.Errorf("filtered mapper is a %T, want a database mapper", m)
+	}
+}
+
+func TestPreloadLimit(t *testing.T) {
+	filename := createTestDB(t, t.TempDir(), "")
+	small, err := OpenWithOptions(filename, &OpenOptions{Preload: [][2]string{{"c.src", "a.src"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := small.PreloadedBytes()
+
+	// a.src<>b.src is selected first, but only a.src<>c.src fits
+	x, err := OpenWithOptions(filename, &OpenOptions{PreloadAll: true, MaxPreloadBytes: size + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := x.PreloadedBytes(); got != size {
+		t.Errorf("PreloadedBytes() = %d, want %d", got, size)
+	}
+	m, _ := x.GetMapper("b.src", "a.src")
+	if _, ok := m.(*dbMapper); !ok {
+		t.Errorf("mapping over the limit is a %T, want a database mapper", m)
+	}
+	if ids, _ := m.Get("R3_2"); !reflect.DeepEqual(ids, []string{"L3"}) {
+		t.Errorf("Get(R3_2) = %q, want L3", ids)
+	}
+	m, _ = x.GetMapper("a.src", "c.src")
+	if _, ok := m.(*memMapper); !ok {
+		t.Errorf("mapping under the limit is a %T, want a preloaded mapper", m)
+	}
+
+	_, err = OpenWithOptions(filename, &OpenOptions{Preload: [][2]string{{"a.src", "x.src"}}})
+	if err == nil {
+		t.Error("preloading an unknown mapping succeeded")
+	}
+}
+
+func TestPreloadReleases(t *testing.T) {
+	dir := t.TempDir()
+	createTestDB(t, dir, "2020-06")
+
+	// the release has no a.src<>c.src mapping, which is skipped
+	releases, err := OpenReleases(dir, &OpenOptions{Preload: [][2]string{{"a.src", "c.src"}, {"b.src", "a.src"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, ok := releases["2020-06"]
+	if !ok {
+		t.Fatalf("releases = %v", releases.IDs())
+	}
+	m, err := rel.GetMapper("a.src", "b.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*memMapper); !ok {
+		t.Errorf("release mapping is a %T, want a preloaded mapper", m)
+	}
+}
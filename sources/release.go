@@ -0,0 +1,107 @@
+package sources
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Release describes a named snapshot of a source database, which can be
+// used to reproduce a mapping exactly.
+type Release struct {
+	// ID of the release, e.g. "2020-06".
+	ID string
+
+	// Created is when the snapshot was taken.
+	Created time.Time
+
+	// Description of the release.
+	Description string
+
+	// Checksums of the indexes and mappings of each source, by name.
+	Checksums map[string]string
+}
+
+// Releases are source databases loaded side by side, by release ID.
+type Releases map[string]*Database
+
+// OpenReleases opens each release snapshot (*.sqlite) in a directory, and
+// preloads the mappings selected by opts (which may be nil) into memory.
+func OpenReleases(dir string, opts *OpenOptions) (Releases, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.sqlite"))
+	if err != nil {
+		return nil, err
+	}
+	res := make(Releases)
+	for _, name := range names {
+		db, err := OpenWithOptions(name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if db.Release == nil {
+			return nil, fmt.Errorf("%s: not a release snapshot", name)
+		}
+		if _, ok := res[db.Release.ID]; ok {
+			return nil, fmt.Errorf("%s: duplicate release %s", name, db.Release.ID)
+		}
+		res[db.Release.ID] = db
+	}
+	return res, nil
+}
+
+// IDs returns the release IDs, oldest first.
+func (r Releases) IDs() []string {
+	var ids []string
+	for id := range r {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return r[ids[i]].Release.Created.Before(r[ids[j]].Release.Created)
+	})
+	return ids
+}
+
+// ReleaseSnapshotPath returns the filename of a release snapshot in dir.
+func ReleaseSnapshotPath(dir, releaseID string) string {
+	return filepath.Join(dir, releaseID+".sqlite")
+}
+
+// readRelease returns the release of a snapshot database, or nil for a
+// database which is still being updated.
+func readRelease(sdb *sql.DB) (*Release, error) {
+	var n int
+	err := sdb.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type='table' AND name='release_snapshot';`).Scan(&n)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	r := &Release{Checksums: make(map[string]string)}
+	err = sdb.QueryRow("SELECT release_id FROM release_snapshot;").Scan(&r.ID)
+	if err != nil {
+		return nil, err
+	}
+	var desc sql.NullString
+	err = sdb.QueryRow("SELECT created, description FROM releases WHERE release_id=?;",
+		r.ID).Scan(&r.Created, &desc)
+	if err != nil {
+		return nil, err
+	}
+	r.Description = desc.String
+
+	rows, err := sdb.Query(`SELECT s.name, r.checksum FROM sources s, release_sources r
+		WHERE s.source_id=r.source_id AND r.release_id=?;`, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, sum string
+		if err = rows.Scan(&name, &sum); err != nil {
+			return nil, err
+		}
+		r.Checksums[name] = sum
+	}
+	return r, rows.Err()
+}
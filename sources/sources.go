@@ -17,6 +17,11 @@ import (
 const (
 	exampleHitSize   = 10
 	defaultCacheSize = 16 * 1024
+
+	// mapperBatchSize is the number of identifiers looked up by each query
+	// of GetMany, leaving room for the filter values under SQLite's default
+	// limit of 999 parameters.
+	mapperBatchSize = 500
 )
 
 // A Database of source identifiers and references to mapping resources between them.
@@ -25,25 +30,62 @@ type Database struct {
 
 	Sources map[string]*Source
 
+	// Release of a snapshot database, or nil if the database is not a
+	// release snapshot.
+	Release *Release
+
 	mappings map[string]map[string]string
+	tables   map[string]map[string]*mappingTable
 	mappers  map[string]*dbMapper
+
+	// preloaded mappings held in memory (see OpenOptions)
+	preloaded    map[string]map[string]*memMapper
+	preloadBytes int64
 }
 
 // Mapper represents a one-way mapping between identifier sources.
 type Mapper interface {
 	// Get retrieves ids that map to the given id.
 	Get(leftID string) (rightIDs []string, found bool)
+
+	// GetMany retrieves the ids that map to each of the given ids. Ids
+	// which couldn't be looked up are left out of the result.
+	GetMany(leftIDs []string) map[string][]string
 }
 
 type dbMapper struct {
 	stmt  *sql.Stmt
+	batch *sql.Stmt     // looks up mapperBatchSize identifiers
+	args  []interface{} // filter values following the identifiers
 	mu    sync.RWMutex
 	cache *Cache
 }
 
+// newDBMapper prepares the query of a single identifier, and the query of
+// a batch of identifiers (see mappingTable.batchQuery).
+func newDBMapper(sdb *sql.DB, q, batch string, args []interface{}) (*dbMapper, error) {
+	stmt, err := sdb.Prepare(q)
+	if err != nil {
+		return nil, err
+	}
+	bstmt, err := sdb.Prepare(batch)
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	return &dbMapper{
+		stmt:  stmt,
+		batch: bstmt,
+		args:  args,
+		mu:    sync.RWMutex{},
+		cache: NewCache(defaultCacheSize),
+	}, nil
+}
+
 func (m *dbMapper) Close() {
 	m.cache.Clear()
 	m.stmt.Close()
+	m.batch.Close()
 }
 
 func (m *dbMapper) Get(leftID string) (rightIDs []string, found bool) {
@@ -53,7 +95,7 @@ func (m *dbMapper) Get(leftID string) (rightIDs []string, found bool) {
 		return r, ok
 	}
 	m.mu.RUnlock()
-	rows, err := m.stmt.Query(leftID)
+	rows, err := m.stmt.Query(append([]interface{}{leftID}, m.args...)...)
 	if err == sql.ErrNoRows {
 		m.mu.Lock()
 		m.cache.Add(leftID, []string{})
@@ -82,8 +124,92 @@ func (m *dbMapper) Get(leftID string) (rightIDs []string, found bool) {
 	return
 }
 
+func (m *dbMapper) GetMany(leftIDs []string) map[string][]string {
+	res := make(map[string][]string, len(leftIDs))
+	var missing []string
+	m.mu.RLock()
+	for _, id := range leftIDs {
+		if _, ok := res[id]; ok {
+			continue
+		}
+		if r, ok := m.cache.Get(id); ok {
+			res[id] = r
+			continue
+		}
+		res[id] = nil
+		missing = append(missing, id)
+	}
+	m.mu.RUnlock()
+
+	for len(missing) > 0 {
+		n := len(missing)
+		if n > mapperBatchSize {
+			n = mapperBatchSize
+		}
+		if err := m.getBatch(missing[:n], res); err != nil {
+			log.Println(err)
+			for _, id := range missing[:n] {
+				delete(res, id)
+			}
+		}
+		missing = missing[n:]
+	}
+	return res
+}
+
+// getBatch looks up at most mapperBatchSize identifiers in one query, and
+// adds them to the cache and to res.
+func (m *dbMapper) getBatch(leftIDs []string, res map[string][]string) error {
+	args := make([]interface{}, 0, mapperBatchSize+len(m.args))
+	for _, id := range leftIDs {
+		args = append(args, id)
+	}
+	// pad a short batch with a repeated identifier, so that one prepared
+	// statement serves every batch
+	for len(args) < mapperBatchSize {
+		args = append(args, leftIDs[0])
+	}
+	args = append(args, m.args...)
+
+	rows, err := m.batch.Query(args...)
+	if err != nil {
+		return err
+	}
+	found := make(map[string][]string, len(leftIDs))
+	for rows.Next() {
+		var l, r string
+		if err = rows.Scan(&l, &r); err != nil {
+			rows.Close()
+			return err
+		}
+		found[l] = append(found[l], r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for _, id := range leftIDs {
+		r, ok := found[id]
+		if !ok {
+			r = []string{}
+		}
+		m.cache.Add(id, r)
+		res[id] = r
+	}
+	m.mu.Unlock()
+	return nil
+}
+
 // Open a source database and load it into memory.
 func Open(filename string) (*Database, error) {
+	return OpenWithOptions(filename, nil)
+}
+
+// OpenWithOptions opens a source database, and preloads the mappings
+// selected by opts into memory.
+func OpenWithOptions(filename string, opts *OpenOptions) (*Database, error) {
 	sdb, err := sql.Open("sqlite3", filename)
 	if err != nil {
 		return nil, err
@@ -133,7 +259,8 @@ func Open(filename string) (*Database, error) {
 		rows.Close()
 	}
 
-	rows, err = sdb.Query(`SELECT a.name, b.name, c.map_query_lr, c.map_query_rl
+	rows, err = sdb.Query(`SELECT a.name, b.name, c.map_query_lr, c.map_query_rl,
+		c.left_source_id, c.right_source_id
 		FROM sources a, sources b, source_mappings c
 		WHERE a.source_id=c.left_source_id AND b.source_id=c.right_source_id;`)
 	if err != nil {
@@ -141,31 +268,55 @@ func Open(filename string) (*Database, error) {
 	}
 
 	maps := make(map[string]map[string]string)
+	tables := make(map[string]map[string]*mappingTable)
 	for rows.Next() {
 		var left, right, q1, q2 string
-		err = rows.Scan(&left, &right, &q1, &q2)
+		var leftID, rightID int64
+		err = rows.Scan(&left, &right, &q1, &q2, &leftID, &rightID)
 		if err != nil {
 			rows.Close()
 			return nil, err
 		}
 		if _, ok := maps[left]; !ok {
 			maps[left] = make(map[string]string)
+			tables[left] = make(map[string]*mappingTable)
 		}
 		if _, ok := maps[right]; !ok {
 			maps[right] = make(map[string]string)
+			tables[right] = make(map[string]*mappingTable)
 		}
 		maps[left][right] = q1
 		maps[right][left] = q2
+
+		name := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+		tables[left][right] = &mappingTable{name: name, from: "left_id", to: "right_id"}
+		tables[right][left] = &mappingTable{name: name, from: "right_id", to: "left_id"}
 	}
 	rows.Close()
+	if err = readPairAttributes(sdb, tables); err != nil {
+		return nil, err
+	}
+
+	if err = readDeprecations(sdb, srcs); err != nil {
+		return nil, err
+	}
+	rel, err := readRelease(sdb)
+	if err != nil {
+		return nil, err
+	}
 
 	db := &Database{
 		db:       sdb,
 		Sources:  srcs,
+		Release:  rel,
 		mappings: maps,
+		tables:   tables,
 		mappers:  make(map[string]*dbMapper),
 	}
-	return db, err
+	if err = db.preload(opts); err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
 // SourceHit describes a search hit and some statistics.
@@ -211,21 +362,19 @@ func (x *Database) GetMapper(fromID, toID string) (Mapper, error) {
 	if !ok {
 		return nil, errors.New("databio/sources: no supported mapping")
 	}
+	if pm, ok := x.preloaded[fromID][toID]; ok {
+		return pm, nil
+	}
 
 	m, ok := x.mappers[q]
 	if ok {
 		return m, nil
 	}
 
-	stmt, err := x.db.Prepare(q)
+	m, err := newDBMapper(x.db, q, x.tables[fromID][toID].batchQuery(nil), nil)
 	if err != nil {
 		return nil, err
 	}
-	m = &dbMapper{
-		stmt:  stmt,
-		mu:    sync.RWMutex{},
-		cache: NewCache(defaultCacheSize),
-	}
 	x.mappers[q] = m
 	return m, nil
 }
@@ -236,6 +385,9 @@ func (x *Database) GetMapper(fromID, toID string) (Mapper, error) {
 func (x *Database) DetermineSource(sample []string) []*SourceHit {
 	var res []*SourceHit
 	for srcName, src := range x.Sources {
+		if src.Deprecated {
+			continue
+		}
 		for subsetName, bf := range src.Subsets {
 			uhits := make(map[string]struct{})
 			var hits uint64
@@ -287,6 +439,34 @@ type Source struct {
 
 	Subsets    map[string]*BloomFilter
 	LastUpdate time.Time
+
+	// Deprecated sources are not detected, but can still be mapped.
+	Deprecated bool
+}
+
+// readDeprecations marks the deprecated sources, if the database records
+// any.
+func readDeprecations(sdb *sql.DB, srcs map[string]*Source) error {
+	var n int
+	err := sdb.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type='table' AND name='source_deprecations';`).Scan(&n)
+	if err != nil || n == 0 {
+		return err
+	}
+	rows, err := sdb.Query(`SELECT s.name FROM sources s, source_deprecations d
+		WHERE s.source_id=d.source_id;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		srcs[name].Deprecated = true
+	}
+	return rows.Err()
 }
 
 // Linkout directly to an identifier if supported.
@@ -0,0 +1,144 @@
+package sources
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// createTestDB writes a source database with sources a.src, b.src and
+// c.src, a mapping from a.src to b.src where L<i> maps to R<i>_<j> for
+// each j < i%4 with rank j+1, and, unless it is a release snapshot, a
+// small mapping from a.src to c.src.
+func createTestDB(t *testing.T, dir, release string) string {
+	t.Helper()
+	filename := filepath.Join(dir, "sources.sqlite")
+	if release != "" {
+		filename = filepath.Join(dir, release+".sqlite")
+	}
+	sdb, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	tx, err := sdb.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	exec := func(q string, args ...interface{}) {
+		t.Helper()
+		if _, err := tx.Exec(q, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec(`CREATE TABLE sources (source_id integer primary key, name varchar, description varchar,
+		ident_type varchar, url varchar, id_url varchar, citedata varchar);`)
+	exec(`CREATE TABLE source_indexes (source_id integer, subset varchar, bloom blob,
+		last_update datetime, element_count integer);`)
+	exec(`CREATE TABLE source_mappings (left_source_id integer, right_source_id integer,
+		mapfilename varchar, map_query_lr varchar, map_query_rl varchar);`)
+	for i, name := range []string{"a.src", "b.src", "c.src"} {
+		exec(`INSERT INTO sources VALUES (?,?,?,'text','','','');`, i+1, name, name)
+	}
+	addMapping := func(leftID, rightID int, attrs string) string {
+		table := fmt.Sprintf("mapping_%d_to_%d", leftID, rightID)
+		exec(`CREATE TABLE ` + table + ` (left_id varchar, right_id varchar` + attrs + `,
+			primary key(left_id,right_id));`)
+		exec(`INSERT INTO source_mappings VALUES (?,?,'test.tsv',?,?);`, leftID, rightID,
+			"SELECT right_id FROM "+table+" WHERE left_id=?;",
+			"SELECT left_id FROM "+table+" WHERE right_id=?;")
+		return table
+	}
+
+	addMapping(1, 2, ", rank integer")
+	for i := 0; i < 3000; i++ {
+		for j := 0; j < i%4; j++ {
+			exec(`INSERT INTO mapping_1_to_2 VALUES (?,?,?);`,
+				fmt.Sprintf("L%d", i), fmt.Sprintf("R%d_%d", i, j), j+1)
+		}
+	}
+	if release == "" {
+		addMapping(1, 3, "")
+		for i := 0; i < 10; i++ {
+			exec(`INSERT INTO mapping_1_to_3 VALUES (?,?);`, fmt.Sprintf("L%d", i), fmt.Sprintf("C%d", i))
+		}
+	} else {
+		exec(`CREATE TABLE release_snapshot (release_id varchar);`)
+		exec(`CREATE TABLE releases (release_id varchar, created datetime, description varchar);`)
+		exec(`CREATE TABLE release_sources (release_id varchar, source_id integer, checksum varchar);`)
+		exec(`INSERT INTO release_snapshot VALUES (?);`, release)
+		exec(`INSERT INTO releases VALUES (?,'2020-06-01T00:00:00Z','test release');`, release)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func sortedIDs(ids []string) []string {
+	res := append([]string{}, ids...)
+	sort.Strings(res)
+	return res
+}
+
+func TestGetManyMatchesGet(t *testing.T) {
+	filename := createTestDB(t, t.TempDir(), "")
+	var ids []string
+	for i := 0; i < 3200; i++ {
+		// more than one batch, with repeated and unknown identifiers
+		ids = append(ids, fmt.Sprintf("L%d", i%3100))
+	}
+
+	for _, f := range []*MappingFilter{nil, {MaxRank: 1}} {
+		t.Run(fmt.Sprint(f), func(t *testing.T) {
+			many, err := Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			one, err := Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := many.GetFilteredMapper("a.src", "b.src", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m1, err := one.GetFilteredMapper("a.src", "b.src", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// some identifiers are cached already
+			m.Get("L5")
+			res := m.GetMany(ids)
+			if len(res) != 3100 {
+				t.Fatalf("GetMany returned %d identifiers, want 3100", len(res))
+			}
+			for _, id := range ids {
+				want, ok := m1.Get(id)
+				if !ok {
+					t.Fatalf("Get(%q) not found", id)
+				}
+				got, ok := res[id]
+				if !ok {
+					t.Fatalf("GetMany left out %q", id)
+				}
+				if len(got)+len(want) > 0 && !reflect.DeepEqual(sortedIDs(got), sortedIDs(want)) {
+					t.Fatalf("GetMany[%q] = %q, Get = %q", id, got, want)
+				}
+				// the results are cached
+				if cached, _ := m.Get(id); len(cached) != len(got) {
+					t.Fatalf("cached Get(%q) = %q, want %q", id, cached, got)
+				}
+			}
+			if n := len(m.GetMany(nil)); n != 0 {
+				t.Errorf("GetMany(nil) returned %d identifiers", n)
+			}
+		})
+	}
+}